@@ -1,9 +1,9 @@
 module github.com/IvanKyrylov/user-game-api
 
-
 go 1.16
 
 require (
 	github.com/ilyakaznacheev/cleanenv v1.2.5
 	go.mongodb.org/mongo-driver v1.5.1
+	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073
 )
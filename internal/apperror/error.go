@@ -3,17 +3,65 @@ package apperror
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 )
 
+// Codes are stable identifiers of the error kinds, clients should match on
+// them instead of messages. Each code maps to one HTTP status.
+const (
+	CodeSystem           = "NS-000001"
+	CodeBadRequest       = "NS-000002"
+	CodeNotFound         = "NS-000003"
+	CodeValidation       = "NS-000004"
+	CodeConflict         = "NS-000005"
+	CodeUnauthorized     = "NS-000006"
+	CodeForbidden        = "NS-000007"
+	CodeMethodNotAllowed = "NS-000008"
+	CodeRateLimited      = "NS-000009"
+	CodeUnavailable      = "NS-000010"
+	CodeTimeout          = "NS-000011"
+	CodeInvalidID        = "NS-000012"
+)
+
+var statuses = map[string]int{
+	CodeSystem:           http.StatusInternalServerError,
+	CodeBadRequest:       http.StatusBadRequest,
+	CodeNotFound:         http.StatusNotFound,
+	CodeValidation:       http.StatusUnprocessableEntity,
+	CodeConflict:         http.StatusConflict,
+	CodeUnauthorized:     http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+	CodeRateLimited:      http.StatusTooManyRequests,
+	CodeUnavailable:      http.StatusServiceUnavailable,
+	CodeTimeout:          http.StatusGatewayTimeout,
+	CodeInvalidID:        http.StatusBadRequest,
+}
+
+// Sentinels match any AppError with the same code through errors.Is.
 var (
-	ErrNotFound = NewAppError("not found", "NS-000003", "")
+	ErrNotFound     = NewAppError("not found", CodeNotFound, "")
+	ErrInvalidID    = NewAppError("invalid id", CodeInvalidID, "id must be a 24 character hex string")
+	ErrConflict     = NewAppError("conflict", CodeConflict, "")
+	ErrUnauthorized = NewAppError("unauthorized", CodeUnauthorized, "")
+	ErrForbidden    = NewAppError("forbidden", CodeForbidden, "")
+	ErrRateLimited  = NewAppError("too many requests", CodeRateLimited, "")
+	ErrUnavailable  = NewAppError("service unavailable", CodeUnavailable, "")
+	ErrTimeout      = NewAppError("request timed out", CodeTimeout, "")
 )
 
 type AppError struct {
-	Err              error  `json:"-"`
-	Message          string `json:"message,omitempty"`
-	DeveloperMessage string `json:"developer_message,omitempty"`
-	Code             string `json:"code,omitempty"`
+	Err              error             `json:"-"`
+	Message          string            `json:"message,omitempty"`
+	DeveloperMessage string            `json:"developer_message,omitempty"`
+	Code             string            `json:"code,omitempty"`
+	Fields           map[string]string `json:"fields,omitempty"`
+	// Allow lists the methods of a MethodNotAllowedError, sent in the Allow header.
+	Allow []string `json:"-"`
+	// RetryAfter of a RateLimitedError is sent in the Retry-After header.
+	RetryAfter time.Duration `json:"-"`
 }
 
 func NewAppError(message, code, developerMessage string) *AppError {
@@ -33,6 +81,20 @@ func (e *AppError) Unwrap() error {
 	return e.Err
 }
 
+// Is reports whether target is an AppError with the same code.
+func (e *AppError) Is(target error) bool {
+	t, ok := target.(*AppError)
+	return ok && t.Code == e.Code
+}
+
+// Status returns the HTTP status of the error code, 500 for unknown codes.
+func (e *AppError) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
 func (e *AppError) Marshal() []byte {
 	bytes, err := json.Marshal(e)
 	if err != nil {
@@ -42,9 +104,48 @@ func (e *AppError) Marshal() []byte {
 }
 
 func BadRequestError(message string) *AppError {
-	return NewAppError(message, "NS-000002", "some thing wrong with user data")
+	return NewAppError(message, CodeBadRequest, "some thing wrong with user data")
+}
+
+// ValidationError carries per field validation messages.
+func ValidationError(fields map[string]string) *AppError {
+	appErr := NewAppError("validation failed", CodeValidation, "some thing wrong with user data")
+	appErr.Fields = fields
+	return appErr
+}
+
+func NotFoundError(message string) *AppError {
+	return NewAppError(message, CodeNotFound, "")
+}
+
+func ConflictError(message string) *AppError {
+	return NewAppError(message, CodeConflict, "")
+}
+
+func UnauthorizedError(message string) *AppError {
+	return NewAppError(message, CodeUnauthorized, "")
+}
+
+func ForbiddenError(message string) *AppError {
+	return NewAppError(message, CodeForbidden, "")
+}
+
+func MethodNotAllowedError(allow ...string) *AppError {
+	appErr := NewAppError("method not allowed", CodeMethodNotAllowed, "allowed methods: "+strings.Join(allow, ", "))
+	appErr.Allow = allow
+	return appErr
+}
+
+func RateLimitedError(retryAfter time.Duration) *AppError {
+	appErr := NewAppError("too many requests", CodeRateLimited, "")
+	appErr.RetryAfter = retryAfter
+	return appErr
+}
+
+func UnavailableError(developerMessage string) *AppError {
+	return NewAppError("service unavailable", CodeUnavailable, developerMessage)
 }
 
 func systemError(developerMessage string) *AppError {
-	return NewAppError("system error", "NS-000001", developerMessage)
+	return NewAppError("system error", CodeSystem, developerMessage)
 }
@@ -1,10 +1,30 @@
 package apperror
 
 import (
+	"context"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
 )
 
+// NotFoundHandler responds with ErrNotFound to requests no route matches.
+func NotFoundHandler() http.Handler {
+	return Middleware(func(w http.ResponseWriter, r *http.Request) error {
+		return ErrNotFound
+	})
+}
+
+// MethodNotAllowedHandler responds with a MethodNotAllowedError listing allow.
+func MethodNotAllowedHandler(allow []string) http.Handler {
+	return Middleware(func(w http.ResponseWriter, r *http.Request) error {
+		return MethodNotAllowedError(allow...)
+	})
+}
+
 type appHandler func(http.ResponseWriter, *http.Request) error
 
 func Middleware(h appHandler) http.HandlerFunc {
@@ -12,19 +32,32 @@ func Middleware(h appHandler) http.HandlerFunc {
 		var appErr *AppError
 		err := h(w, r)
 		if err != nil {
-			if errors.As(err, &appErr) {
-				if errors.Is(err, ErrNotFound) {
-					w.WriteHeader(http.StatusNotFound)
-					w.Write(ErrNotFound.Marshal())
-					return
+			if !errors.As(err, &appErr) {
+				if errors.Is(err, context.DeadlineExceeded) {
+					appErr = ErrTimeout
+				} else {
+					appErr = systemError(err.Error())
 				}
-				err := err.(*AppError)
-				w.WriteHeader(http.StatusBadRequest)
-				w.Write(err.Marshal())
+			}
+			if appErr.Status() >= http.StatusInternalServerError {
+				logging.Default().WithContext(r.Context()).Error("request failed", "code", appErr.Code, "error", err)
+			}
+
+			if len(appErr.Allow) > 0 {
+				w.Header().Set("Allow", strings.Join(appErr.Allow, ", "))
+			}
+			if appErr.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(appErr.RetryAfter.Seconds()))))
+			}
+			if negotiate(r) == FormatProblem {
+				w.Header().Set("Content-Type", contentTypeProblem)
+				w.WriteHeader(appErr.Status())
+				w.Write(appErr.MarshalProblem(r.URL.Path))
 				return
 			}
-			w.WriteHeader(418)
-			w.Write(systemError(err.Error()).Marshal())
+			w.Header().Set("Content-Type", contentTypeJSON)
+			w.WriteHeader(appErr.Status())
+			w.Write(appErr.Marshal())
 		}
 	}
 }
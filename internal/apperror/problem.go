@@ -0,0 +1,86 @@
+package apperror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error response formats. FormatJSON is the {message, developer_message, code}
+// body, FormatProblem is RFC 7807 application/problem+json.
+const (
+	FormatJSON    = "json"
+	FormatProblem = "problem"
+)
+
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeProblem = "application/problem+json"
+)
+
+var defaultFormat = FormatJSON
+
+// SetFormat sets the format used unless the request Accept header asks for
+// application/problem+json.
+func SetFormat(format string) error {
+	switch format {
+	case FormatJSON, FormatProblem:
+		defaultFormat = format
+		return nil
+	}
+	return fmt.Errorf("unknown error format %q, use %q or %q", format, FormatJSON, FormatProblem)
+}
+
+// Problem is the RFC 7807 rendering of an AppError. Code, DeveloperMessage and
+// Fields are extension members carrying the same data as the JSON format.
+type Problem struct {
+	Type             string            `json:"type"`
+	Title            string            `json:"title"`
+	Status           int               `json:"status"`
+	Detail           string            `json:"detail,omitempty"`
+	Instance         string            `json:"instance,omitempty"`
+	Code             string            `json:"code,omitempty"`
+	DeveloperMessage string            `json:"developer_message,omitempty"`
+	Fields           map[string]string `json:"fields,omitempty"`
+}
+
+// Problem describes the error as it occurred for the request at instance.
+// The type is about:blank, so the title is the status phrase and clients
+// tell the errors apart by code.
+func (e *AppError) Problem(instance string) Problem {
+	status := e.Status()
+	return Problem{
+		Type:             "about:blank",
+		Title:            http.StatusText(status),
+		Status:           status,
+		Detail:           e.Message,
+		Instance:         instance,
+		Code:             e.Code,
+		DeveloperMessage: e.DeveloperMessage,
+		Fields:           e.Fields,
+	}
+}
+
+func (e *AppError) MarshalProblem(instance string) []byte {
+	bytes, err := json.Marshal(e.Problem(instance))
+	if err != nil {
+		return nil
+	}
+	return bytes
+}
+
+// negotiate returns the error format for r.
+func negotiate(r *http.Request) string {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if i := strings.Index(mediaRange, ";"); i >= 0 {
+				mediaRange = mediaRange[:i]
+			}
+			if strings.TrimSpace(mediaRange) == contentTypeProblem {
+				return FormatProblem
+			}
+		}
+	}
+	return defaultFormat
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/pkg/jwt"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+)
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	bearerPrefix = "Bearer "
+	// leeway tolerates clock skew between the token issuer and the API.
+	leeway = 30 * time.Second
+)
+
+// Identity is the authenticated caller. Subject is the API key name or the
+// sub claim of the token, Scopes are granted by the Role.
+type Identity struct {
+	Subject string
+	Method  string
+	Role    string
+	Scopes  []string
+}
+
+// tokenClaims are the claims of the accepted tokens. A token without a role
+// claim gets RolePublic, a scope claim narrows down the scopes of the role.
+type tokenClaims struct {
+	jwt.Claims
+	Role  string `json:"role,omitempty"`
+	Scope string `json:"scope,omitempty"`
+}
+
+type contextKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the caller identity put into the request context by
+// Authenticator.Middleware.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
+
+// Authenticator accepts static API keys in the X-API-Key header and JWT
+// bearer tokens signed with the configured key.
+type Authenticator struct {
+	apiKeys  map[string]string
+	apiRoles map[string]string
+	key      *jwt.Key
+	issuer   string
+	audience string
+	logger   *logging.Logger
+}
+
+// NewAuthenticator builds an Authenticator from API keys and their roles by
+// name and an optional token key. Keys without a role get RolePublic. Issuer
+// and audience are checked when not empty.
+func NewAuthenticator(apiKeys, apiRoles map[string]string, key *jwt.Key, issuer, audience string, logger *logging.Logger) *Authenticator {
+	return &Authenticator{
+		apiKeys:  apiKeys,
+		apiRoles: apiRoles,
+		key:      key,
+		issuer:   issuer,
+		audience: audience,
+		logger:   logger,
+	}
+}
+
+func (a *Authenticator) Authenticate(r *http.Request) (identity Identity, err error) {
+	if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+		return a.authenticateAPIKey(apiKey)
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		if !strings.HasPrefix(authorization, bearerPrefix) {
+			return identity, apperror.UnauthorizedError("authorization scheme must be Bearer")
+		}
+		return a.authenticateToken(strings.TrimPrefix(authorization, bearerPrefix))
+	}
+	return identity, apperror.UnauthorizedError("credentials are required")
+}
+
+func (a *Authenticator) authenticateAPIKey(apiKey string) (identity Identity, err error) {
+	// Compare with every key, so the time taken does not tell which one matched.
+	for name, key := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+			identity = Identity{Subject: name, Method: MethodAPIKey}
+		}
+	}
+	if identity.Subject == "" {
+		return identity, apperror.UnauthorizedError("api key is invalid")
+	}
+
+	identity.Role = RolePublic
+	if role, ok := a.apiRoles[identity.Subject]; ok {
+		identity.Role = role
+	}
+	identity.Scopes = scopesOf(identity.Role, nil)
+	return identity, nil
+}
+
+func (a *Authenticator) authenticateToken(token string) (identity Identity, err error) {
+	if a.key == nil {
+		return identity, apperror.UnauthorizedError("bearer tokens are not accepted")
+	}
+
+	var claims tokenClaims
+	if err = a.key.Verify(token, &claims); err != nil {
+		return identity, apperror.UnauthorizedError(err.Error())
+	}
+	if err = claims.Validate(time.Now(), leeway, a.issuer, a.audience); err != nil {
+		return identity, apperror.UnauthorizedError(err.Error())
+	}
+	if claims.Subject == "" {
+		return identity, apperror.UnauthorizedError("token subject is required")
+	}
+	if claims.Role == "" {
+		claims.Role = RolePublic
+	}
+	if !IsRole(claims.Role) {
+		return identity, apperror.UnauthorizedError("token role is unknown")
+	}
+	return Identity{
+		Subject: claims.Subject,
+		Method:  MethodJWT,
+		Role:    claims.Role,
+		Scopes:  scopesOf(claims.Role, parseScope(claims.Scope)),
+	}, nil
+}
+
+// Middleware rejects requests without valid credentials with 401 and passes
+// the caller identity to next in the request context. The access log entry of
+// a rejected request gets the reason as auth_error.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := a.Authenticate(r)
+		if err != nil {
+			a.logger.WithContext(r.Context()).Warn("authentication failed", "error", err)
+			logging.Annotate(r.Context(), "auth_error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="user-game-api"`)
+			apperror.Middleware(func(w http.ResponseWriter, r *http.Request) error {
+				return err
+			})(w, r)
+			return
+		}
+		logging.Annotate(r.Context(), "subject", identity.Subject, "role", identity.Role)
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	})
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/pkg/jwt"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+)
+
+func newKey(t *testing.T) *jwt.Key {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(path, []byte("0123456789abcdef0123456789abcdef"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.LoadKey(jwt.HS256, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newAuthenticator(t *testing.T, key *jwt.Key) *Authenticator {
+	t.Helper()
+	logger, err := logging.New(ioutil.Discard, logging.FormatJSON, logging.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuthenticator(
+		map[string]string{"web": "web-key", "support": "support-key", "ops": "ops-key"},
+		map[string]string{"support": RoleAdmin, "ops": RolePlayer},
+		key, "issuer", "api", logger)
+}
+
+func sign(t *testing.T, key *jwt.Key, claims tokenClaims) string {
+	t.Helper()
+	token, err := key.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticate(t *testing.T) {
+	key := newKey(t)
+	a := newAuthenticator(t, key)
+	exp := time.Now().Add(time.Minute).Unix()
+	valid := jwt.Claims{Subject: "alice", Issuer: "issuer", Audience: jwt.Audience{"api"}, ExpiresAt: exp}
+	issued, err := NewIssuer(key, "issuer", "api", time.Minute).Issue("bob", RolePlayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		header      string
+		value       string
+		wantSubject string
+		wantRole    string
+		wantScopes  string
+		wantErr     bool
+		errContains string
+	}{
+		{name: "api key without role", header: apiKeyHeader, value: "web-key", wantSubject: "web", wantRole: RolePublic, wantScopes: "read"},
+		{name: "api key with role", header: apiKeyHeader, value: "support-key", wantSubject: "support", wantRole: RoleAdmin, wantScopes: "read write pii"},
+		{name: "api key of player", header: apiKeyHeader, value: "ops-key", wantSubject: "ops", wantRole: RolePlayer, wantScopes: "read"},
+		{name: "unknown api key", header: apiKeyHeader, value: "other-key", wantErr: true, errContains: "api key is invalid"},
+		{name: "prefix of an api key", header: apiKeyHeader, value: "web", wantErr: true, errContains: "api key is invalid"},
+		{name: "no credentials", wantErr: true, errContains: "credentials are required"},
+		{name: "basic scheme", header: "Authorization", value: "Basic d2ViOmtleQ==", wantErr: true, errContains: "must be Bearer"},
+		{name: "issued token", header: "Authorization", value: "Bearer " + issued, wantSubject: "bob", wantRole: RolePlayer, wantScopes: "read"},
+		{
+			name: "token without role", header: "Authorization",
+			value:       "Bearer " + sign(t, key, tokenClaims{Claims: valid}),
+			wantSubject: "alice", wantRole: RolePublic, wantScopes: "read",
+		},
+		{
+			name: "token scope narrows the role", header: "Authorization",
+			value:       "Bearer " + sign(t, key, tokenClaims{Claims: valid, Role: RoleAdmin, Scope: "read pii"}),
+			wantSubject: "alice", wantRole: RoleAdmin, wantScopes: "read pii",
+		},
+		{
+			name: "token with unknown role", header: "Authorization",
+			value:   "Bearer " + sign(t, key, tokenClaims{Claims: valid, Role: "root"}),
+			wantErr: true, errContains: "role is unknown",
+		},
+		{
+			name: "token without subject", header: "Authorization",
+			value:   "Bearer " + sign(t, key, tokenClaims{Claims: jwt.Claims{Issuer: "issuer", Audience: jwt.Audience{"api"}, ExpiresAt: exp}}),
+			wantErr: true, errContains: "subject is required",
+		},
+		{
+			name: "token of another audience", header: "Authorization",
+			value:   "Bearer " + sign(t, key, tokenClaims{Claims: jwt.Claims{Subject: "alice", Issuer: "issuer", Audience: jwt.Audience{"web"}, ExpiresAt: exp}}),
+			wantErr: true, errContains: jwt.ErrAudience.Error(),
+		},
+		{name: "malformed token", header: "Authorization", value: "Bearer abc", wantErr: true, errContains: jwt.ErrMalformed.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			identity, err := a.Authenticate(r)
+			if tt.wantErr {
+				if !errors.Is(err, apperror.ErrUnauthorized) || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("err = %v, want unauthorized with %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if identity.Subject != tt.wantSubject || identity.Role != tt.wantRole || strings.Join(identity.Scopes, " ") != tt.wantScopes {
+				t.Errorf("identity = %+v, want %s %s with %s", identity, tt.wantSubject, tt.wantRole, tt.wantScopes)
+			}
+		})
+	}
+
+	t.Run("tokens not accepted", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+issued)
+		if _, err := newAuthenticator(t, nil).Authenticate(r); !errors.Is(err, apperror.ErrUnauthorized) {
+			t.Errorf("err = %v, want unauthorized", err)
+		}
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	var got Identity
+	var accessLog bytes.Buffer
+	logger, err := logging.New(&accessLog, logging.FormatJSON, logging.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := logging.AccessLog(logger, logging.AccessLogOptions{SampleRate: 1})(newAuthenticator(t, nil).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = FromContext(r.Context())
+		})))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+	if challenge := w.Header().Get("WWW-Authenticate"); challenge != `Bearer realm="user-game-api"` {
+		t.Errorf("WWW-Authenticate = %q", challenge)
+	}
+	if !strings.Contains(w.Body.String(), apperror.CodeUnauthorized) {
+		t.Errorf("body = %s, want the code %s", w.Body.String(), apperror.CodeUnauthorized)
+	}
+	if got.Subject != "" {
+		t.Errorf("next called with %+v", got)
+	}
+	if !strings.Contains(accessLog.String(), `"auth_error":"credentials are required"`) {
+		t.Errorf("access log = %s, want the auth error", accessLog.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(apiKeyHeader, "support-key")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || got.Subject != "support" || got.Method != MethodAPIKey {
+		t.Errorf("status = %d, identity = %+v, want 200 with support", w.Code, got)
+	}
+	if !strings.Contains(accessLog.String(), `"subject":"support","role":"admin"`) {
+		t.Errorf("access log = %s, want the caller", accessLog.String())
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/pkg/jwt"
+)
+
+// Issuer signs access tokens accepted by an Authenticator with the same key,
+// issuer and audience.
+type Issuer struct {
+	key      *jwt.Key
+	issuer   string
+	audience string
+	ttl      time.Duration
+}
+
+// NewIssuer builds an Issuer of tokens valid for ttl. key must be able to sign.
+func NewIssuer(key *jwt.Key, issuer, audience string, ttl time.Duration) *Issuer {
+	return &Issuer{
+		key:      key,
+		issuer:   issuer,
+		audience: audience,
+		ttl:      ttl,
+	}
+}
+
+// TTL is the lifetime of the issued tokens.
+func (i *Issuer) TTL() time.Duration {
+	return i.ttl
+}
+
+// Issue returns a token for subject with role.
+func (i *Issuer) Issue(subject, role string) (string, error) {
+	now := time.Now()
+	claims := tokenClaims{
+		Claims: jwt.Claims{
+			Subject:   subject,
+			Issuer:    i.issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(i.ttl).Unix(),
+		},
+		Role: role,
+	}
+	if i.audience != "" {
+		claims.Audience = jwt.Audience{i.audience}
+	}
+	return i.key.Sign(claims)
+}
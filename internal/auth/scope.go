@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+)
+
+// Roles are assigned to API keys in config and to tokens by the role claim.
+// RolePlayer is the role of the tokens issued to player accounts.
+const (
+	RolePublic = "public"
+	RolePlayer = "player"
+	RoleAdmin  = "admin"
+)
+
+// Scopes grant access: ScopeRead to the read endpoints, ScopeWrite to the
+// endpoints changing data and ScopePII to personal fields of the users.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopePII   = "pii"
+)
+
+var roleScopes = map[string][]string{
+	RolePublic: {ScopeRead},
+	RolePlayer: {ScopeRead},
+	RoleAdmin:  {ScopeRead, ScopeWrite, ScopePII},
+}
+
+// IsRole reports whether role is known.
+func IsRole(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
+// scopesOf returns the scopes of role, limited to requested when it is not
+// empty. Unknown roles get no scopes.
+func scopesOf(role string, requested []string) []string {
+	if len(requested) == 0 {
+		return roleScopes[role]
+	}
+	var scopes []string
+	for _, scope := range roleScopes[role] {
+		for _, r := range requested {
+			if r == scope {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the caller of ctx has scope. A caller without an
+// identity, e.g. with authentication disabled, has the scopes of RolePublic.
+func HasScope(ctx context.Context, scope string) bool {
+	identity, ok := FromContext(ctx)
+	if !ok {
+		identity = Identity{Role: RolePublic, Scopes: scopesOf(RolePublic, nil)}
+	}
+	return identity.HasScope(scope)
+}
+
+// RequireScope wraps h, so that callers without scope get 403.
+func RequireScope(scope string, h func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !HasScope(r.Context(), scope) {
+			return apperror.ForbiddenError("scope " + scope + " is required")
+		}
+		return h(w, r)
+	}
+}
+
+// Redact clears the struct fields tagged pii:"true" anywhere in v unless the
+// caller of ctx has ScopePII. v must be a pointer, the fields are changed in
+// place and disappear from JSON through their omitempty option.
+func Redact(ctx context.Context, v interface{}) {
+	if HasScope(ctx, ScopePII) {
+		return
+	}
+	redact(reflect.ValueOf(v))
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			redact(v.Elem())
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		elem := v.Elem()
+		// A struct held by an interface is not addressable, redact a copy.
+		if elem.Kind() == reflect.Struct && v.CanSet() {
+			copied := reflect.New(elem.Type()).Elem()
+			copied.Set(elem)
+			redact(copied)
+			v.Set(copied)
+			return
+		}
+		redact(elem)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			redact(v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+			if t.Field(i).Tag.Get("pii") == "true" {
+				field.Set(reflect.Zero(field.Type()))
+				continue
+			}
+			redact(field)
+		}
+	}
+}
+
+// parseScope splits the space separated scope claim.
+func parseScope(scope string) []string {
+	return strings.Fields(scope)
+}
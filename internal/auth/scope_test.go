@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+)
+
+type person struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty" pii:"true"`
+}
+
+type envelope struct {
+	Items   interface{} `json:"items"`
+	Owner   *person     `json:"owner"`
+	Friends [2]person   `json:"friends"`
+}
+
+func withScopes(scopes ...string) context.Context {
+	return WithIdentity(context.Background(), Identity{Subject: "test", Role: RolePublic, Scopes: scopes})
+}
+
+func newEnvelope() envelope {
+	return envelope{
+		Items:   []person{{"Ann", "ann@example.com"}},
+		Owner:   &person{"Bob", "bob@example.com"},
+		Friends: [2]person{{"Eve", "eve@example.com"}},
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		kept bool
+	}{
+		{"without pii", withScopes(ScopeRead), false},
+		{"no identity", context.Background(), false},
+		{"with pii", withScopes(ScopeRead, ScopePII), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newEnvelope()
+			Redact(tt.ctx, &v)
+			items := v.Items.([]person)
+			if items[0].Name != "Ann" || v.Owner.Name != "Bob" || v.Friends[0].Name != "Eve" {
+				t.Errorf("envelope = %+v, want the names kept", v)
+			}
+			for _, email := range []string{items[0].Email, v.Owner.Email, v.Friends[0].Email} {
+				if (email != "") != tt.kept {
+					t.Errorf("envelope = %+v, want the emails kept %v", v, tt.kept)
+				}
+			}
+		})
+	}
+
+	t.Run("struct held by an interface", func(t *testing.T) {
+		v := struct{ Item interface{} }{person{"Ann", "ann@example.com"}}
+		Redact(withScopes(ScopeRead), &v)
+		if item := v.Item.(person); item.Email != "" || item.Name != "Ann" {
+			t.Errorf("item = %+v, want the email cleared", item)
+		}
+	})
+}
+
+func TestRequireScope(t *testing.T) {
+	var called bool
+	h := RequireScope(ScopeWrite, func(w http.ResponseWriter, r *http.Request) error {
+		called = true
+		return nil
+	})
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantCalled bool
+	}{
+		{"without scope", withScopes(ScopeRead), false},
+		{"with scope", withScopes(ScopeRead, ScopeWrite), true},
+		{"no identity", context.Background(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			r := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(tt.ctx)
+			err := h(httptest.NewRecorder(), r)
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			if tt.wantCalled && err != nil {
+				t.Errorf("err = %v, want nil", err)
+			}
+			if !tt.wantCalled {
+				var appErr *apperror.AppError
+				if !errors.Is(err, apperror.ErrForbidden) || !errors.As(err, &appErr) || appErr.Status() != http.StatusForbidden {
+					t.Errorf("err = %v, want 403 forbidden", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHasScopeWithoutIdentity(t *testing.T) {
+	ctx := context.Background()
+	if !HasScope(ctx, ScopeRead) || HasScope(ctx, ScopeWrite) || HasScope(ctx, ScopePII) {
+		t.Error("a caller without identity must have only the public scopes")
+	}
+}
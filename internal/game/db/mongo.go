@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/IvanKyrylov/user-game-api/internal/apperror"
 	"github.com/IvanKyrylov/user-game-api/internal/game"
+	"github.com/IvanKyrylov/user-game-api/internal/mongoerr"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,12 +19,14 @@ import (
 
 type db struct {
 	collection *mongo.Collection
-	logger     *log.Logger
+	users      *mongo.Collection
+	logger     *logging.Logger
 }
 
-func NewStorage(storage *mongo.Database, collection string, logger *log.Logger) game.Storage {
+func NewStorage(storage *mongo.Database, collection, usersCollection string, logger *logging.Logger) game.Storage {
 	return &db{
 		collection: storage.Collection(collection),
+		users:      storage.Collection(usersCollection),
 		logger:     logger,
 	}
 }
@@ -31,7 +35,7 @@ func (s *db) FindById(ctx context.Context, id string) (game game.Game, err error
 
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return game, fmt.Errorf("failed to convert hex to objectid. error: %w", err)
+		return game, apperror.ErrInvalidID
 	}
 
 	filter := bson.M{"_id": objectId}
@@ -41,120 +45,239 @@ func (s *db) FindById(ctx context.Context, id string) (game game.Game, err error
 
 	result := s.collection.FindOne(ctx, filter)
 
-	if result.Err() != nil {
-		s.logger.Fatal(result.Err())
-		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-			return game, apperror.ErrNotFound
-		}
-		return game, fmt.Errorf("failed to execute query. error: %w", err)
+	if err = result.Err(); err != nil {
+		s.logger.WithContext(ctx).Debug("failed to find game", "id", id, "error", err)
+		return game, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
 	}
 	if err = result.Decode(&game); err != nil {
-		return game, fmt.Errorf("failed to decode document. error: %w", err)
+		return game, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
 	}
 	return game, nil
 }
 
-func (s *db) FindByPlayer(ctx context.Context, uuid string, limit, page int64) (games []game.Game, err error) {
+type createdCursor struct {
+	Created time.Time          `json:"created"`
+	ID      primitive.ObjectID `json:"id"`
+}
+
+func filterQuery(filter game.Filter) (bson.M, error) {
+	query := bson.M{}
+
+	if filter.UserID != "" {
+		userId, err := primitive.ObjectIDFromHex(filter.UserID)
+		if err != nil {
+			return query, apperror.ErrInvalidID
+		}
+		query["user_id"] = userId
+	}
+	if filter.GameType != nil {
+		query["game_type"] = *filter.GameType
+	}
+	if filter.WinStatus != nil {
+		query["win_status"] = *filter.WinStatus
+	}
+
+	created := bson.M{}
+	if !filter.CreatedFrom.IsZero() {
+		created["$gte"] = filter.CreatedFrom
+	}
+	if !filter.CreatedTo.IsZero() {
+		created["$lt"] = filter.CreatedTo
+	}
+	if len(created) > 0 {
+		query["created"] = created
+	}
+
+	return query, nil
+}
+
+func (s *db) FindAll(ctx context.Context, filter game.Filter, p pagination.Params) (games []game.Game, nextCursor string, err error) {
 
-	userId, err := primitive.ObjectIDFromHex(uuid)
+	query, err := filterQuery(filter)
 	if err != nil {
-		return games, fmt.Errorf("failed to convert hex to objectid. error: %w", err)
+		return games, nextCursor, err
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{"created", -1}, {"_id", -1}}).SetLimit(p.Limit)
+
+	if p.Keyset {
+		findOptions.SetLimit(p.Limit + 1)
+		if p.Cursor != "" {
+			var cursor createdCursor
+			if err = pagination.DecodeCursor(p.Cursor, &cursor); err != nil {
+				return games, nextCursor, err
+			}
+			query["$or"] = []bson.M{
+				{"created": bson.M{"$lt": cursor.Created}},
+				{"created": cursor.Created, "_id": bson.M{"$lt": cursor.ID}},
+			}
+		}
+	} else {
+		findOptions.SetSkip(p.Page * p.Limit)
 	}
-	filter := bson.M{"user_id": userId}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	cur, err := s.collection.Find(ctx, filter, options.Find().SetLimit(limit).SetSkip(page*limit))
+	cur, err := s.collection.Find(ctx, query, findOptions)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return games, apperror.ErrNotFound
-		}
-		return games, fmt.Errorf("failed to execute query. error: %w", err)
+		return games, nextCursor, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	if err = cur.All(ctx, &games); err != nil {
+		return games, nextCursor, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
 	}
 
-	if err = cur.All(ctx, &games); err == nil {
-		return games, nil
+	if p.Keyset && int64(len(games)) > p.Limit {
+		games = games[:p.Limit]
+		last := games[len(games)-1]
+		nextCursor, err = pagination.EncodeCursor(createdCursor{Created: last.Created, ID: last.ID})
+		if err != nil {
+			return games, nextCursor, fmt.Errorf("failed to encode cursor. error: %w", err)
+		}
 	}
-	return games, fmt.Errorf("failed to decode document. error: %w", err)
+	return games, nextCursor, nil
 }
 
-func (s *db) FindAll(ctx context.Context, limit, page int64) (games []game.Game, err error) {
+func (s *db) Count(ctx context.Context, filter game.Filter) (count int64, err error) {
+
+	query, err := filterQuery(filter)
+	if err != nil {
+		return count, err
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	cur, err := s.collection.Find(ctx, bson.M{}, options.Find().SetLimit(limit).SetSkip(page*limit))
+	if len(query) == 0 {
+		count, err = s.collection.EstimatedDocumentCount(ctx)
+	} else {
+		count, err = s.collection.CountDocuments(ctx, query)
+	}
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return games, apperror.ErrNotFound
-		}
-		return games, fmt.Errorf("failed to execute query. error: %w", err)
+		return count, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
 	}
+	return count, nil
+}
 
-	if err = cur.All(ctx, &games); err == nil {
-		return games, nil
-	}
-	return games, fmt.Errorf("failed to decode document. error: %w", err)
+// bucketFormats are the $dateToString formats matching game.StatisticsQuery.Bucket.
+var bucketFormats = map[string]string{
+	game.GranularityHour:  "%Y-%m-%dT%H:00",
+	game.GranularityDay:   "%Y-%m-%d",
+	game.GranularityWeek:  "%G-W%V",
+	game.GranularityMonth: "%Y-%m",
+	game.GranularityYear:  "%Y",
 }
 
-func (s *db) AggregateGamesStatistics(ctx context.Context, uuid string, startDate, endDate time.Time) (gamesStatistics []game.GamesStatistics, err error) {
-	userId, err := primitive.ObjectIDFromHex(uuid)
-	if err != nil {
-		return gamesStatistics, fmt.Errorf("failed to convert hex to objectid. error: %w", err)
+func (s *db) AggregateGamesStatistics(ctx context.Context, query game.StatisticsQuery) (gamesStatistics []game.GamesStatistics, err error) {
+	match := bson.M{
+		"created": bson.M{
+			"$gte": query.StartDate,
+			"$lt":  query.EndDate,
+		},
+	}
+	if query.UserID != "" {
+		userId, err := primitive.ObjectIDFromHex(query.UserID)
+		if err != nil {
+			return gamesStatistics, apperror.ErrInvalidID
+		}
+		match["user_id"] = userId
 	}
 
+	format, ok := bucketFormats[query.Granularity]
+	if !ok {
+		format = bucketFormats[game.GranularityDay]
+	}
 	dateProjectStage := bson.M{
-		"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created"},
-	}
-
-	groupedByDayStages := []bson.D{
-		{{"$project", bson.M{
-			"date":    dateProjectStage,
-			"created": true,
-		}}},
-		{{"$group", bson.M{
-			"_id":          "$date",
-			"games_played": bson.M{"$sum": 1},
-		}}},
-		{{"$project", bson.M{
-			"date":         "$_id",
-			"games_played": true,
-			"_id":          false,
-		}}},
-	}
-	withGameTypeStages := []bson.D{
-		{{"$project", bson.M{
-			"date":      dateProjectStage,
-			"created":   true,
-			"game_type": true,
-		}}},
-		{{"$group", bson.M{
-			"_id": bson.M{
-				"date":      "$date",
-				"game_type": "$game_type",
-			},
-			"games_played": bson.M{"$sum": int64(1)},
-		}}},
-		{{"$project", bson.M{
-			"date":         "$_id.date",
-			"game_type":    "$_id.game_type",
-			"games_played": true,
-			"_id":          false,
-		}}},
+		"$dateToString": bson.M{"format": format, "date": "$created", "timezone": query.Location.String()},
+	}
+
+	// groupStages counts the games per bucket and per value of each of fields.
+	groupStages := func(fields ...string) []bson.D {
+		project := bson.M{"date": dateProjectStage}
+		id := bson.M{"date": "$date"}
+		output := bson.M{"date": "$_id.date", "games_played": true, "_id": false}
+		sort := bson.D{{"date", 1}}
+		for _, field := range fields {
+			project[field] = true
+			id[field] = "$" + field
+			output[field] = "$_id." + field
+			sort = append(sort, bson.E{field, 1})
+		}
+		return []bson.D{
+			{{"$project", project}},
+			{{"$group", bson.M{
+				"_id":          id,
+				"games_played": bson.M{"$sum": int64(1)},
+			}}},
+			{{"$project", output}},
+			{{"$sort", sort}},
+		}
+	}
+
+	facets := bson.M{
+		"group_by_day":    groupStages(),
+		"with_game_type":  groupStages("game_type"),
+		"with_win_status": groupStages("win_status"),
+	}
+
+	if query.UserID == "" {
+		// activePlayersStages counts the distinct players per date formatted with format.
+		activePlayersStages := func(format string) []bson.D {
+			return []bson.D{
+				{{"$group", bson.M{
+					"_id": bson.M{
+						"date": bson.M{
+							"$dateToString": bson.M{"format": format, "date": "$created", "timezone": query.Location.String()},
+						},
+						"user_id": "$user_id",
+					},
+				}}},
+				{{"$group", bson.M{
+					"_id":            "$_id.date",
+					"active_players": bson.M{"$sum": int64(1)},
+				}}},
+				{{"$project", bson.M{
+					"date":           "$_id",
+					"active_players": true,
+					"_id":            false,
+				}}},
+				{{"$sort", bson.M{"date": 1}}},
+			}
+		}
+
+		facets["daily_active_players"] = activePlayersStages("%Y-%m-%d")
+		facets["monthly_active_players"] = activePlayersStages("%Y-%m")
+		facets["by_game_type"] = []bson.D{
+			{{"$group", bson.M{
+				"_id":            "$game_type",
+				"games_played":   bson.M{"$sum": int64(1)},
+				"wins":           bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$win_status", 1}}, 1, 0}}},
+				"points_total":   bson.M{"$sum": "$points_gained"},
+				"points_avg":     bson.M{"$avg": "$points_gained"},
+				"points_min":     bson.M{"$min": "$points_gained"},
+				"points_max":     bson.M{"$max": "$points_gained"},
+				"points_std_dev": bson.M{"$stdDevPop": "$points_gained"},
+			}}},
+			{{"$project", bson.M{
+				"game_type":      "$_id",
+				"games_played":   true,
+				"wins":           true,
+				"win_ratio":      bson.M{"$divide": bson.A{"$wins", "$games_played"}},
+				"points_total":   true,
+				"points_avg":     true,
+				"points_min":     true,
+				"points_max":     true,
+				"points_std_dev": true,
+				"_id":            false,
+			}}},
+			{{"$sort", bson.M{"game_type": 1}}},
+		}
 	}
+
 	pipeline := []bson.D{
-		{{"$match", bson.M{
-			"user_id": userId,
-			"created": bson.M{
-				"$gte": startDate,
-				"$lte": endDate,
-			},
-		}}},
-		{{"$facet", bson.M{
-			"group_by_day":   groupedByDayStages,
-			"with_game_type": withGameTypeStages,
-		}}},
+		{{"$match", match}},
+		{{"$facet", facets}},
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -162,72 +285,50 @@ func (s *db) AggregateGamesStatistics(ctx context.Context, uuid string, startDat
 
 	cur, err := s.collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return gamesStatistics, apperror.ErrNotFound
-		}
-		return gamesStatistics, fmt.Errorf("failed to execute query. error: %w", err)
-	}
-
-	// for cur.Next(ctx) {
-	// 	var elem bson.M
-	// 	err := cur.Decode(&elem)
-	// 	if err != nil {
-	// 		return gamesStatistics, fmt.Errorf("failed to decode document. error: %w", err)
-	// 	}
-
-	// 	// groupByDay := elem["group_by_day"].(primitive.A)
-	// 	// withGameType := elem["with_game_type"].(primitive.A)
-
-	// 	gameStatistic := game.GamesStatistics{
-	// 		GroupByDay: make([]struct {
-	// 			GroupDate   time.Time "json:\"date\""
-	// 			GamesPlayed int64     "json:\"games_played\""
-	// 		}, len(elem["group_by_day"].(primitive.A))),
-	// 		WithGameType: make([]struct {
-	// 			GameDate    time.Time "json:\"date\""
-	// 			GameType    int8      "json:\"game_type\""
-	// 			GamesPlayed int64     "json:\"games_played\""
-	// 		}, len(elem["with_game_type"].(primitive.A))),
-	// 	}
-
-	// 	for i, v := range elem["group_by_day"].(primitive.A) {
-	// 		temp := v.()/(map[string]interface{})
-	// 		gameStatistic.GroupByDay[i].GroupDate = temp["date"].(time.Time)
-	// 		gameStatistic.GroupByDay[i].GamesPlayed = temp["games_played"].(int64)
-
-	// 	}
-
-	// 	for i, v := range elem["with_game_type"].(primitive.A) {
-	// 		temp := v.(map[string]interface{})
-	// 		gameStatistic.WithGameType[i].GameDate = temp["date"].(time.Time)
-	// 		gameStatistic.WithGameType[i].GameType = temp["game_type"].(int8)
-	// 		gameStatistic.WithGameType[i].GamesPlayed = temp["games_played"].(int64)
-	// 	}
-
-	// 	// temp := game.GamesStatistics{
-	// 	// 	GroupByDay: struct {
-	// 	// 		GroupDate   time.Time "json:\"date\""
-	// 	// 		GamesPlayed int64     "json:\"games_played\""
-	// 	// 	}{
-	// 	// 		GroupDate:   groupByDay["date"].(time.Time),
-	// 	// 		GamesPlayed: groupByDay["games_played"].(int64),
-	// 	// 	},
-	// 	// 	WithGameType: struct {
-	// 	// 		GameDate    time.Time "json:\"date\""
-	// 	// 		GameType    int8      "json:\"game_type\""
-	// 	// 		GamesPlayed int64     "json:\"games_played\""
-	// 	// 	}{
-	// 	// 		GameDate:    withGameType["date"].(time.Time),
-	// 	// 		GameType:    withGameType["game_type"].(int8),
-	// 	// 		GamesPlayed: withGameType["games_played"].(int64),
-	// 	// 	},
-	// 	// }
-	// 	gamesStatistics = gameStatistic
-
-	// }
-	// return gamesStatistics, fmt.Errorf("failed to decode document. error: %w", err)
+		return gamesStatistics, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
 	if err = cur.All(ctx, &gamesStatistics); err == nil {
 		return gamesStatistics, nil
 	}
-	return gamesStatistics, fmt.Errorf("failed to decode document. error: %w", err)
+	return gamesStatistics, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+}
+
+func (s *db) Create(ctx context.Context, game game.Game) (id string, err error) {
+	game.ID = primitive.NewObjectID()
+	newUserGame := bson.D{
+		{"_id", game.ID},
+		{"points_gained", game.PointsGained},
+		{"win_status", game.WinStatus},
+		{"game_type", game.GameType},
+		{"user_id", game.UserID},
+		{"created", game.Created},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	session, err := s.collection.Database().Client().StartSession()
+	if err != nil {
+		return id, fmt.Errorf("failed to start session. error: %w", mongoerr.Translate(err))
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		result, err := s.users.UpdateOne(sessCtx, bson.M{"_id": game.UserID}, bson.M{"$inc": bson.M{"rating": int64(1)}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, apperror.ErrNotFound
+		}
+		return s.collection.InsertOne(sessCtx, newUserGame)
+	})
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return id, err
+		}
+		return id, fmt.Errorf("failed to execute transaction. error: %w", mongoerr.Translate(err))
+	}
+	return game.ID.Hex(), nil
 }
@@ -0,0 +1,80 @@
+package game
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var winStatuses = map[int8]bool{
+	0: true,
+	1: true,
+}
+
+type CreateGameDTO struct {
+	UserID       string `json:"user_id"`
+	PointsGained *int   `json:"points_gained"`
+	WinStatus    *int8  `json:"win_status"`
+	GameType     *int8  `json:"game_type"`
+	Created      string `json:"created,omitempty"`
+}
+
+// Validate checks the DTO fields and returns a message per invalid field.
+func (d CreateGameDTO) Validate() map[string]string {
+	fields := make(map[string]string)
+
+	if d.UserID == "" {
+		fields["user_id"] = "is required"
+	} else if !primitive.IsValidObjectID(d.UserID) {
+		fields["user_id"] = "must be a valid object id"
+	}
+	if d.PointsGained == nil {
+		fields["points_gained"] = "is required"
+	} else if *d.PointsGained < 0 {
+		fields["points_gained"] = "must not be negative"
+	}
+	if d.WinStatus == nil {
+		fields["win_status"] = "is required"
+	} else if !winStatuses[*d.WinStatus] {
+		fields["win_status"] = "must be 0 or 1"
+	}
+	if d.GameType == nil {
+		fields["game_type"] = "is required"
+	} else if *d.GameType <= 0 {
+		fields["game_type"] = "must be a positive integer"
+	}
+	if d.Created != "" {
+		created, err := time.Parse(time.RFC3339, d.Created)
+		if err != nil {
+			fields["created"] = "must be an RFC 3339 timestamp"
+		} else if created.After(time.Now()) {
+			fields["created"] = "must not be in the future"
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+func (d CreateGameDTO) toGame() Game {
+	userId, _ := primitive.ObjectIDFromHex(d.UserID)
+	game := Game{
+		UserID:  userId,
+		Created: time.Now().UTC(),
+	}
+	if d.PointsGained != nil {
+		game.PointsGained = *d.PointsGained
+	}
+	if d.WinStatus != nil {
+		game.WinStatus = *d.WinStatus
+	}
+	if d.GameType != nil {
+		game.GameType = *d.GameType
+	}
+	if created, err := time.Parse(time.RFC3339, d.Created); err == nil {
+		game.Created = created.UTC()
+	}
+	return game
+}
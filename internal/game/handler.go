@@ -2,44 +2,54 @@ package game
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/auth"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"github.com/IvanKyrylov/user-game-api/pkg/router"
 )
 
+// Paths are relative to the API prefix the handler is registered under.
 const (
-	gamesURL        = "/api/games"
-	gameURL         = "/api/game/"
-	gamesStatistics = "/api/games-statistics"
+	gamesURL        = "/games"
+	gameURL         = "/games/{id}"
+	gamesStatistics = "/games-statistics"
+	playerGamesURL  = "/users/{uuid}/games"
+
+	// legacyGameURL and legacyPlayerGamesURL are the singular forms the API
+	// was first published with, kept as aliases.
+	legacyGameURL        = "/game/{id}"
+	legacyPlayerGamesURL = "/user/{uuid}/games"
 )
 
 type Handler struct {
-	Logger      *log.Logger
+	Logger      *logging.Logger
 	GameService Service
 }
 
-func (h *Handler) Register(router *http.ServeMux) {
-	router.HandleFunc(gameURL, apperror.Middleware(h.GetGame))
-	router.HandleFunc(gamesURL, apperror.Middleware(h.GetAllGames))
-	router.HandleFunc(gamesStatistics, apperror.Middleware(h.GetGamesStatistics))
+func (h *Handler) Register(router *router.Router) {
+	router.HandleFunc(http.MethodGet, gamesURL, apperror.Middleware(h.GetAllGames))
+	router.HandleFunc(http.MethodPost, gamesURL, apperror.Middleware(auth.RequireScope(auth.ScopeWrite, h.CreateGame)))
+	router.HandleFunc(http.MethodGet, gamesStatistics, apperror.Middleware(h.GetGamesStatistics))
+
+	for _, url := range []string{gameURL, legacyGameURL} {
+		router.HandleFunc(http.MethodGet, url, apperror.Middleware(h.GetGame))
+	}
+	for _, url := range []string{playerGamesURL, legacyPlayerGamesURL} {
+		router.HandleFunc(http.MethodGet, url, apperror.Middleware(h.GetGamesByPlayer))
+	}
 }
 
 func (h *Handler) GetGame(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		return apperror.BadRequestError("metod GET")
-	}
-	h.Logger.Println("GET GAME")
+	h.Logger.WithContext(r.Context()).Debug("get game")
 
 	w.Header().Set("Content-Type", "application/json")
 
-	h.Logger.Println("get id from path")
-	id := r.URL.Path[len(gameURL):]
-	if id == "" {
-		return apperror.BadRequestError("id query parameter is required and must be a comma separated integers")
-	}
+	id := router.Param(r, "id")
 
 	game, err := h.GameService.GetById(r.Context(), id)
 	if err != nil {
@@ -58,37 +68,27 @@ func (h *Handler) GetGame(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (h *Handler) GetGamesByPlayer(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		return apperror.BadRequestError("metod GET")
-	}
-
-	h.Logger.Println("GET GAMES BY PLAYER")
+	h.Logger.WithContext(r.Context()).Debug("get games by player")
 	w.Header().Set("Content-Type", "application/json")
 
-	h.Logger.Println("get player id from URL")
-	uuid := r.URL.Query().Get("uuid")
-	if uuid == "" {
-		return apperror.BadRequestError("uuid query parameter is required and must be a comma separated integers")
-	}
+	uuid := router.Param(r, "uuid")
 
-	h.Logger.Println("get limit from URL")
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil || limit <= 0 {
-		return apperror.BadRequestError("limit query parameter is required positive integers")
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
 	}
 
-	h.Logger.Println("get limit from URL")
-	page, err := strconv.Atoi(r.URL.Query().Get("page"))
-	if err != nil || page <= 0 {
-		return apperror.BadRequestError("page query parameter is required positive integers")
+	p, err := pagination.Parse(r)
+	if err != nil {
+		return err
 	}
 
-	games, err := h.GameService.GetByPlayer(r.Context(), uuid, int64(limit), int64(page))
+	page, err := h.GameService.GetByPlayer(r.Context(), uuid, filter, p)
 	if err != nil {
 		return err
 	}
 
-	gamesBytes, err := json.Marshal(games)
+	gamesBytes, err := json.Marshal(pagination.NewEnvelope(r, p, page))
 	if err != nil {
 		return err
 	}
@@ -100,31 +100,26 @@ func (h *Handler) GetGamesByPlayer(w http.ResponseWriter, r *http.Request) error
 }
 
 func (h *Handler) GetAllGames(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		return apperror.BadRequestError("metod GET")
-	}
-
-	h.Logger.Println("GET ALL GAMES")
+	h.Logger.WithContext(r.Context()).Debug("get all games")
 	w.Header().Set("Content-Type", "application/json")
 
-	h.Logger.Println("get limit from URL")
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil || limit < 0 {
-		return apperror.BadRequestError("limit query parameter is required positive integers")
+	filter, err := parseFilter(r)
+	if err != nil {
+		return err
 	}
+	filter.UserID = r.URL.Query().Get("user_id")
 
-	h.Logger.Println("get limit from URL")
-	page, err := strconv.Atoi(r.URL.Query().Get("page"))
-	if err != nil || page < 0 {
-		return apperror.BadRequestError("page query parameter is required positive integers")
+	p, err := pagination.Parse(r)
+	if err != nil {
+		return err
 	}
 
-	games, err := h.GameService.GetAll(r.Context(), int64(limit), int64(page))
+	page, err := h.GameService.GetAll(r.Context(), filter, p)
 	if err != nil {
 		return err
 	}
 
-	gamesBytes, err := json.Marshal(games)
+	gamesBytes, err := json.Marshal(pagination.NewEnvelope(r, p, page))
 	if err != nil {
 		return err
 	}
@@ -135,23 +130,99 @@ func (h *Handler) GetAllGames(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func (h *Handler) GetGamesStatistics(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		return apperror.BadRequestError("metod GET")
+// parseFilter reads the game_type, win_status, created_from and created_to
+// query parameters. Timestamps are RFC 3339 or yyyy-mm-dd dates in UTC.
+func parseFilter(r *http.Request) (filter Filter, err error) {
+	query := r.URL.Query()
+
+	if v := query.Get("game_type"); v != "" {
+		gameType, err := strconv.ParseInt(v, 10, 8)
+		if err != nil {
+			return filter, apperror.BadRequestError("game_type query parameter must be an integer")
+		}
+		filter.GameType = new(int8)
+		*filter.GameType = int8(gameType)
+	}
+	if v := query.Get("win_status"); v != "" {
+		winStatus, err := strconv.ParseInt(v, 10, 8)
+		if err != nil || !winStatuses[int8(winStatus)] {
+			return filter, apperror.BadRequestError("win_status query parameter must be 0 or 1")
+		}
+		filter.WinStatus = new(int8)
+		*filter.WinStatus = int8(winStatus)
+	}
+
+	parseTime := func(v string) (time.Time, error) {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", v)
 	}
+	if v := query.Get("created_from"); v != "" {
+		if filter.CreatedFrom, err = parseTime(v); err != nil {
+			return filter, apperror.BadRequestError("created_from query parameter must be an RFC 3339 timestamp or yyyy-mm-dd date")
+		}
+	}
+	if v := query.Get("created_to"); v != "" {
+		if filter.CreatedTo, err = parseTime(v); err != nil {
+			return filter, apperror.BadRequestError("created_to query parameter must be an RFC 3339 timestamp or yyyy-mm-dd date")
+		}
+	}
+	if !filter.CreatedFrom.IsZero() && !filter.CreatedTo.IsZero() && !filter.CreatedFrom.Before(filter.CreatedTo) {
+		return filter, apperror.BadRequestError("created_from should be before created_to")
+	}
+
+	return filter, nil
+}
+
+func (h *Handler) CreateGame(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("create game")
+	w.Header().Set("Content-Type", "application/json")
+
+	var dto CreateGameDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("request body must be a valid game JSON object")
+	}
+
+	id, err := h.GameService.Create(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
 
-	h.Logger.Println("get user id from URL")
+func (h *Handler) GetGamesStatistics(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	// without userId the statistics cover all players
 	userId := r.URL.Query().Get("userId")
-	if len(userId) < 0 {
-		return apperror.BadRequestError("userId null")
+
+	location := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		location, err = time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			return apperror.BadRequestError("tz query parameter must be an IANA time zone name, e.g. Europe/Kiev")
+		}
+	}
+
+	granularity := r.URL.Query().Get("granularity")
+	switch granularity {
+	case "":
+		granularity = GranularityDay
+	case GranularityHour, GranularityDay, GranularityWeek, GranularityMonth, GranularityYear:
+	default:
+		return apperror.BadRequestError("granularity query parameter must be one of hour, day, week, month, year")
 	}
 
-	h.Logger.Println("get start date from URL")
 	startDate := r.URL.Query().Get("startDate")
 	if len(startDate) < 0 {
 		return apperror.BadRequestError("startDate null")
 	}
-	h.Logger.Println("get end date from URL")
 	endDate := r.URL.Query().Get("endDate")
 	if len(endDate) < 0 {
 		return apperror.BadRequestError("endDate NULL")
@@ -159,12 +230,12 @@ func (h *Handler) GetGamesStatistics(w http.ResponseWriter, r *http.Request) err
 
 	dateLayout := "2-1-2006"
 
-	parsedStartDate, err := time.Parse(dateLayout, startDate)
+	parsedStartDate, err := time.ParseInLocation(dateLayout, startDate, location)
 	if err != nil {
 		return apperror.BadRequestError("Invalid startDate format, please use dd-mm-yyyy format")
 	}
 
-	parsedEndDate, err := time.Parse(dateLayout, endDate)
+	parsedEndDate, err := time.ParseInLocation(dateLayout, endDate, location)
 	if err != nil {
 		return apperror.BadRequestError("Invalid endDate format, please use dd-mm-yyyy format")
 	}
@@ -173,7 +244,14 @@ func (h *Handler) GetGamesStatistics(w http.ResponseWriter, r *http.Request) err
 		return apperror.BadRequestError("startDate should not be after endDate")
 	}
 
-	data, err := h.GameService.GetGamesStatistics(r.Context(), userId, parsedStartDate, parsedEndDate)
+	data, err := h.GameService.GetGamesStatistics(r.Context(), StatisticsQuery{
+		UserID:    userId,
+		StartDate: parsedStartDate,
+		// endDate is inclusive, the query range is not
+		EndDate:     parsedEndDate.AddDate(0, 0, 1),
+		Granularity: granularity,
+		Location:    location,
+	})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,45 @@
+package game
+
+import (
+	"context"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/metrics"
+)
+
+// instrumentedStorage observes the duration of the calls to a Storage.
+type instrumentedStorage struct {
+	storage Storage
+	metrics *metrics.StorageMetrics
+}
+
+// NewInstrumentedStorage wraps storage, so its calls are observed by m as the game storage.
+func NewInstrumentedStorage(storage Storage, m *metrics.StorageMetrics) Storage {
+	return &instrumentedStorage{storage: storage, metrics: m}
+}
+
+func (s *instrumentedStorage) FindById(ctx context.Context, id string) (result Game, err error) {
+	defer s.metrics.Observe("game", "FindById", time.Now(), &err)
+	return s.storage.FindById(ctx, id)
+}
+
+func (s *instrumentedStorage) FindAll(ctx context.Context, filter Filter, p pagination.Params) (result []Game, next string, err error) {
+	defer s.metrics.Observe("game", "FindAll", time.Now(), &err)
+	return s.storage.FindAll(ctx, filter, p)
+}
+
+func (s *instrumentedStorage) Count(ctx context.Context, filter Filter) (result int64, err error) {
+	defer s.metrics.Observe("game", "Count", time.Now(), &err)
+	return s.storage.Count(ctx, filter)
+}
+
+func (s *instrumentedStorage) AggregateGamesStatistics(ctx context.Context, query StatisticsQuery) (result []GamesStatistics, err error) {
+	defer s.metrics.Observe("game", "AggregateGamesStatistics", time.Now(), &err)
+	return s.storage.AggregateGamesStatistics(ctx, query)
+}
+
+func (s *instrumentedStorage) Create(ctx context.Context, game Game) (result string, err error) {
+	defer s.metrics.Observe("game", "Create", time.Now(), &err)
+	return s.storage.Create(ctx, game)
+}
@@ -0,0 +1,313 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/game"
+	"github.com/IvanKyrylov/user-game-api/internal/memdb"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type storage struct {
+	db     *memdb.DB
+	logger *logging.Logger
+}
+
+func NewStorage(db *memdb.DB, logger *logging.Logger) game.Storage {
+	return &storage{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *storage) FindById(ctx context.Context, id string) (game game.Game, err error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return game, apperror.ErrInvalidID
+	}
+
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	i, ok := s.db.GameIndex[objectId]
+	if !ok {
+		return game, apperror.ErrNotFound
+	}
+	return s.db.Games[i], nil
+}
+
+type createdCursor struct {
+	Created time.Time          `json:"created"`
+	ID      primitive.ObjectID `json:"id"`
+}
+
+// match reports whether g passes filter. An invalid user id matches nothing.
+func match(filter game.Filter, g game.Game) bool {
+	if filter.UserID != "" && g.UserID.Hex() != filter.UserID {
+		return false
+	}
+	if filter.GameType != nil && g.GameType != *filter.GameType {
+		return false
+	}
+	if filter.WinStatus != nil && g.WinStatus != *filter.WinStatus {
+		return false
+	}
+	if !filter.CreatedFrom.IsZero() && g.Created.Before(filter.CreatedFrom) {
+		return false
+	}
+	if !filter.CreatedTo.IsZero() && !g.Created.Before(filter.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+// newer orders games by created descending, then by id descending.
+func newer(a, b game.Game) bool {
+	if !a.Created.Equal(b.Created) {
+		return a.Created.After(b.Created)
+	}
+	return memdb.Less(b.ID, a.ID)
+}
+
+func (s *storage) FindAll(ctx context.Context, filter game.Filter, p pagination.Params) (games []game.Game, nextCursor string, err error) {
+	var cursor *createdCursor
+	if p.Cursor != "" {
+		cursor = &createdCursor{}
+		if err = pagination.DecodeCursor(p.Cursor, cursor); err != nil {
+			return games, nextCursor, err
+		}
+	}
+
+	s.db.RLock()
+	var matched []game.Game
+	for _, g := range s.db.Games {
+		if match(filter, g) {
+			matched = append(matched, g)
+		}
+	}
+	s.db.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return newer(matched[i], matched[j])
+	})
+
+	if !p.Keyset {
+		start, end := memdb.Page(len(matched), p.Limit, p.Page)
+		return matched[start:end], nextCursor, nil
+	}
+
+	if cursor != nil {
+		last := game.Game{Created: cursor.Created, ID: cursor.ID}
+		start := sort.Search(len(matched), func(i int) bool {
+			return newer(last, matched[i])
+		})
+		matched = matched[start:]
+	}
+	if int64(len(matched)) > p.Limit {
+		matched = matched[:p.Limit]
+		last := matched[len(matched)-1]
+		nextCursor, err = pagination.EncodeCursor(createdCursor{Created: last.Created, ID: last.ID})
+		if err != nil {
+			return games, nextCursor, err
+		}
+	}
+	return matched, nextCursor, nil
+}
+
+func (s *storage) Count(ctx context.Context, filter game.Filter) (count int64, err error) {
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	for _, g := range s.db.Games {
+		if match(filter, g) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *storage) AggregateGamesStatistics(ctx context.Context, query game.StatisticsQuery) (gamesStatistics []game.GamesStatistics, err error) {
+	var userId primitive.ObjectID
+	global := query.UserID == ""
+	if !global {
+		if userId, err = primitive.ObjectIDFromHex(query.UserID); err != nil {
+			return gamesStatistics, apperror.ErrInvalidID
+		}
+	}
+
+	type bucketValue struct {
+		date  string
+		value int8
+	}
+	byDay := make(map[string]int64)
+	byDayGameType := make(map[bucketValue]int64)
+	byDayWinStatus := make(map[bucketValue]int64)
+
+	daily := query
+	daily.Granularity = game.GranularityDay
+	monthly := query
+	monthly.Granularity = game.GranularityMonth
+	dailyPlayers := make(map[string]map[primitive.ObjectID]bool)
+	monthlyPlayers := make(map[string]map[primitive.ObjectID]bool)
+	gameTypes := make(map[int8]*gameTypeAccumulator)
+
+	s.db.RLock()
+	for _, g := range s.db.Games {
+		if !global && g.UserID != userId || g.Created.Before(query.StartDate) || !g.Created.Before(query.EndDate) {
+			continue
+		}
+		date := query.Bucket(g.Created)
+		byDay[date]++
+		byDayGameType[bucketValue{date: date, value: g.GameType}]++
+		byDayWinStatus[bucketValue{date: date, value: g.WinStatus}]++
+
+		if !global {
+			continue
+		}
+		addPlayer(dailyPlayers, daily.Bucket(g.Created), g.UserID)
+		addPlayer(monthlyPlayers, monthly.Bucket(g.Created), g.UserID)
+		if gameTypes[g.GameType] == nil {
+			gameTypes[g.GameType] = &gameTypeAccumulator{}
+		}
+		gameTypes[g.GameType].add(g)
+	}
+	s.db.RUnlock()
+
+	statistics := game.GamesStatistics{
+		GroupByDay:    make([]game.DayStatistics, 0, len(byDay)),
+		WithGameType:  make([]game.GameTypeStatistics, 0, len(byDayGameType)),
+		WithWinStatus: make([]game.WinStatusStatistics, 0, len(byDayWinStatus)),
+	}
+	for date, played := range byDay {
+		statistics.GroupByDay = append(statistics.GroupByDay, game.DayStatistics{
+			GroupDate:   date,
+			GamesPlayed: played,
+		})
+	}
+	for key, played := range byDayGameType {
+		statistics.WithGameType = append(statistics.WithGameType, game.GameTypeStatistics{
+			GameDate:    key.date,
+			GameType:    key.value,
+			GamesPlayed: played,
+		})
+	}
+	for key, played := range byDayWinStatus {
+		statistics.WithWinStatus = append(statistics.WithWinStatus, game.WinStatusStatistics{
+			GameDate:    key.date,
+			WinStatus:   key.value,
+			GamesPlayed: played,
+		})
+	}
+	sort.Slice(statistics.GroupByDay, func(i, j int) bool {
+		return statistics.GroupByDay[i].GroupDate < statistics.GroupByDay[j].GroupDate
+	})
+	sort.Slice(statistics.WithGameType, func(i, j int) bool {
+		a, b := statistics.WithGameType[i], statistics.WithGameType[j]
+		if a.GameDate != b.GameDate {
+			return a.GameDate < b.GameDate
+		}
+		return a.GameType < b.GameType
+	})
+	sort.Slice(statistics.WithWinStatus, func(i, j int) bool {
+		a, b := statistics.WithWinStatus[i], statistics.WithWinStatus[j]
+		if a.GameDate != b.GameDate {
+			return a.GameDate < b.GameDate
+		}
+		return a.WinStatus < b.WinStatus
+	})
+
+	if global {
+		statistics.DailyActivePlayers = activePlayers(dailyPlayers)
+		statistics.MonthlyActivePlayers = activePlayers(monthlyPlayers)
+		for gameType, accumulator := range gameTypes {
+			statistics.ByGameType = append(statistics.ByGameType, accumulator.summary(gameType))
+		}
+		sort.Slice(statistics.ByGameType, func(i, j int) bool {
+			return statistics.ByGameType[i].GameType < statistics.ByGameType[j].GameType
+		})
+	}
+
+	return append(gamesStatistics, statistics), nil
+}
+
+func addPlayer(players map[string]map[primitive.ObjectID]bool, date string, userId primitive.ObjectID) {
+	if players[date] == nil {
+		players[date] = make(map[primitive.ObjectID]bool)
+	}
+	players[date][userId] = true
+}
+
+func activePlayers(players map[string]map[primitive.ObjectID]bool) []game.ActivePlayersStatistics {
+	statistics := make([]game.ActivePlayersStatistics, 0, len(players))
+	for date, users := range players {
+		statistics = append(statistics, game.ActivePlayersStatistics{
+			GameDate:      date,
+			ActivePlayers: int64(len(users)),
+		})
+	}
+	sort.Slice(statistics, func(i, j int) bool {
+		return statistics[i].GameDate < statistics[j].GameDate
+	})
+	return statistics
+}
+
+// gameTypeAccumulator collects the games of one game type for GameTypeSummary.
+type gameTypeAccumulator struct {
+	played, wins    int64
+	total, min, max int64
+	sumOfSquares    float64
+}
+
+func (a *gameTypeAccumulator) add(g game.Game) {
+	points := int64(g.PointsGained)
+	if a.played == 0 || points < a.min {
+		a.min = points
+	}
+	if a.played == 0 || points > a.max {
+		a.max = points
+	}
+	a.played++
+	if g.WinStatus == 1 {
+		a.wins++
+	}
+	a.total += points
+	a.sumOfSquares += float64(points) * float64(points)
+}
+
+func (a *gameTypeAccumulator) summary(gameType int8) game.GameTypeSummary {
+	avg := float64(a.total) / float64(a.played)
+	return game.GameTypeSummary{
+		GameType:     gameType,
+		GamesPlayed:  a.played,
+		Wins:         a.wins,
+		WinRatio:     float64(a.wins) / float64(a.played),
+		PointsTotal:  a.total,
+		PointsAvg:    avg,
+		PointsMin:    a.min,
+		PointsMax:    a.max,
+		PointsStdDev: math.Sqrt(math.Max(a.sumOfSquares/float64(a.played)-avg*avg, 0)),
+	}
+}
+
+func (s *storage) Create(ctx context.Context, game game.Game) (id string, err error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	document, ok := s.db.Users[game.UserID]
+	if !ok {
+		return id, apperror.ErrNotFound
+	}
+
+	game.ID = primitive.NewObjectID()
+	s.db.GameIndex[game.ID] = len(s.db.Games)
+	s.db.Games = append(s.db.Games, game)
+	document.Rating++
+
+	return game.ID.Hex(), nil
+}
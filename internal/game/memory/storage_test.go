@@ -0,0 +1,198 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/game"
+	"github.com/IvanKyrylov/user-game-api/internal/memdb"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/internal/user"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var day = time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// newStorage returns a storage with the users of ids and no games.
+func newStorage(ids ...primitive.ObjectID) (*memdb.DB, game.Storage) {
+	db := memdb.New()
+	for _, id := range ids {
+		db.Users[id] = &memdb.UserDocument{User: user.User{UUID: id}}
+	}
+	return db, NewStorage(db, logging.Default())
+}
+
+func create(t *testing.T, storage game.Storage, g game.Game) string {
+	t.Helper()
+	id, err := storage.Create(context.Background(), g)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return id
+}
+
+func TestCreateIncrementsRating(t *testing.T) {
+	player := primitive.NewObjectID()
+	db, storage := newStorage(player)
+
+	create(t, storage, game.Game{UserID: player, Created: day})
+	create(t, storage, game.Game{UserID: player, Created: day})
+
+	if rating := db.Users[player].Rating; rating != 2 {
+		t.Errorf("rating = %d, want 2", rating)
+	}
+	if _, err := storage.Create(context.Background(), game.Game{UserID: primitive.NewObjectID()}); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("Create for an unknown user: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFindAllKeyset(t *testing.T) {
+	player := primitive.NewObjectID()
+	_, storage := newStorage(player)
+
+	// two games share a created time, so the cursor has to break the tie by id
+	var want []string
+	for _, offset := range []int{4, 3, 3, 2, 1} {
+		want = append(want, create(t, storage, game.Game{UserID: player, Created: day.Add(time.Duration(offset) * time.Hour)}))
+	}
+	if want[1] < want[2] {
+		want[1], want[2] = want[2], want[1]
+	}
+
+	var got []string
+	p := pagination.Params{Limit: 2, Keyset: true}
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("keyset pagination does not end")
+		}
+		games, next, err := storage.FindAll(context.Background(), game.Filter{}, p)
+		if err != nil {
+			t.Fatalf("FindAll: %v", err)
+		}
+		for _, g := range games {
+			got = append(got, g.ID.Hex())
+		}
+		if next == "" {
+			break
+		}
+		p.Cursor = next
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d games, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("game %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFindAllOffsetAndFilter(t *testing.T) {
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	_, storage := newStorage(alice, bob)
+	for i := 0; i < 5; i++ {
+		create(t, storage, game.Game{UserID: alice, Created: day.Add(time.Duration(i) * time.Hour), WinStatus: int8(i % 2)})
+	}
+	create(t, storage, game.Game{UserID: bob, Created: day})
+
+	filter := game.Filter{UserID: alice.Hex()}
+	games, _, err := storage.FindAll(context.Background(), filter, pagination.Params{Limit: 2, Page: 2})
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(games) != 1 || !games[0].Created.Equal(day) {
+		t.Errorf("last page = %v, want the oldest game of alice", games)
+	}
+
+	won := int8(1)
+	count, err := storage.Count(context.Background(), game.Filter{UserID: alice.Hex(), WinStatus: &won})
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("won games of alice = %d, want 2", count)
+	}
+}
+
+func TestFindAllInvalidCursor(t *testing.T) {
+	_, storage := newStorage()
+	_, _, err := storage.FindAll(context.Background(), game.Filter{}, pagination.Params{Limit: 1, Keyset: true, Cursor: "%%%"})
+	if !errors.Is(err, apperror.BadRequestError("")) {
+		t.Errorf("err = %v, want a bad request", err)
+	}
+}
+
+func TestAggregateGamesStatistics(t *testing.T) {
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	_, storage := newStorage(alice, bob)
+	create(t, storage, game.Game{UserID: alice, Created: day, GameType: 1, WinStatus: 1, PointsGained: 10})
+	create(t, storage, game.Game{UserID: alice, Created: day.Add(time.Hour), GameType: 1, PointsGained: 30})
+	create(t, storage, game.Game{UserID: bob, Created: day.Add(2 * time.Hour), GameType: 2, WinStatus: 1, PointsGained: 5})
+	create(t, storage, game.Game{UserID: bob, Created: day.AddDate(0, 0, 1), GameType: 2, PointsGained: 5})
+	// outside the range
+	create(t, storage, game.Game{UserID: bob, Created: day.AddDate(0, 0, 2), GameType: 2})
+
+	query := game.StatisticsQuery{
+		StartDate:   day,
+		EndDate:     day.AddDate(0, 0, 2),
+		Granularity: game.GranularityDay,
+		Location:    time.UTC,
+	}
+
+	t.Run("platform", func(t *testing.T) {
+		statistics, err := storage.AggregateGamesStatistics(context.Background(), query)
+		if err != nil {
+			t.Fatalf("AggregateGamesStatistics: %v", err)
+		}
+		s := statistics[0]
+		wantDays := []game.DayStatistics{{GroupDate: "2021-03-01", GamesPlayed: 3}, {GroupDate: "2021-03-02", GamesPlayed: 1}}
+		if len(s.GroupByDay) != len(wantDays) || s.GroupByDay[0] != wantDays[0] || s.GroupByDay[1] != wantDays[1] {
+			t.Errorf("GroupByDay = %v, want %v", s.GroupByDay, wantDays)
+		}
+		wantActive := []game.ActivePlayersStatistics{{GameDate: "2021-03-01", ActivePlayers: 2}, {GameDate: "2021-03-02", ActivePlayers: 1}}
+		if len(s.DailyActivePlayers) != len(wantActive) || s.DailyActivePlayers[0] != wantActive[0] || s.DailyActivePlayers[1] != wantActive[1] {
+			t.Errorf("DailyActivePlayers = %v, want %v", s.DailyActivePlayers, wantActive)
+		}
+		if len(s.MonthlyActivePlayers) != 1 || s.MonthlyActivePlayers[0].ActivePlayers != 2 {
+			t.Errorf("MonthlyActivePlayers = %v, want 2 players in 2021-03", s.MonthlyActivePlayers)
+		}
+		if len(s.ByGameType) != 2 {
+			t.Fatalf("ByGameType = %v, want game types 1 and 2", s.ByGameType)
+		}
+		first := s.ByGameType[0]
+		if first.GameType != 1 || first.GamesPlayed != 2 || first.Wins != 1 || first.WinRatio != 0.5 ||
+			first.PointsTotal != 40 || first.PointsAvg != 20 || first.PointsMin != 10 || first.PointsMax != 30 || first.PointsStdDev != 10 {
+			t.Errorf("game type 1 = %+v", first)
+		}
+	})
+
+	t.Run("player", func(t *testing.T) {
+		query := query
+		query.UserID = alice.Hex()
+		query.Granularity = game.GranularityMonth
+		statistics, err := storage.AggregateGamesStatistics(context.Background(), query)
+		if err != nil {
+			t.Fatalf("AggregateGamesStatistics: %v", err)
+		}
+		s := statistics[0]
+		if len(s.GroupByDay) != 1 || s.GroupByDay[0] != (game.DayStatistics{GroupDate: "2021-03", GamesPlayed: 2}) {
+			t.Errorf("GroupByDay = %v, want 2 games in 2021-03", s.GroupByDay)
+		}
+		if s.DailyActivePlayers != nil || s.ByGameType != nil {
+			t.Errorf("platform statistics computed for a player: %+v", s)
+		}
+	})
+
+	t.Run("invalid user", func(t *testing.T) {
+		query := query
+		query.UserID = "abc"
+		if _, err := storage.AggregateGamesStatistics(context.Background(), query); !errors.Is(err, apperror.ErrInvalidID) {
+			t.Errorf("err = %v, want ErrInvalidID", err)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package game
 
 import (
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,14 +16,98 @@ type Game struct {
 	UserID       primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
 }
 
+// GamesStatistics counts the played games per time bucket. Despite the field
+// names the buckets follow the requested granularity, day by default.
+// The active players and per game type summaries are only computed for the
+// platform wide statistics, i.e. when no user is selected.
 type GamesStatistics struct {
-	GroupByDay []struct {
-		GroupDate   string `json:"date" bson:"date"`
-		GamesPlayed int64  `json:"games_played" bson:"games_played"`
-	} `json:"group_by_day" bson:"group_by_day"`
-	WithGameType []struct {
-		GameDate    string `json:"date" bson:"date"`
-		GameType    int8   `json:"game_type" bson:"game_type"`
-		GamesPlayed int64  `json:"games_played" bson:"games_played"`
-	} `json:"with_game_type" bson:"with_game_type"`
+	GroupByDay           []DayStatistics           `json:"group_by_day" bson:"group_by_day"`
+	WithGameType         []GameTypeStatistics      `json:"with_game_type" bson:"with_game_type"`
+	WithWinStatus        []WinStatusStatistics     `json:"with_win_status" bson:"with_win_status"`
+	DailyActivePlayers   []ActivePlayersStatistics `json:"daily_active_players,omitempty" bson:"daily_active_players,omitempty"`
+	MonthlyActivePlayers []ActivePlayersStatistics `json:"monthly_active_players,omitempty" bson:"monthly_active_players,omitempty"`
+	ByGameType           []GameTypeSummary         `json:"by_game_type,omitempty" bson:"by_game_type,omitempty"`
+}
+
+type DayStatistics struct {
+	GroupDate   string `json:"date" bson:"date"`
+	GamesPlayed int64  `json:"games_played" bson:"games_played"`
+}
+
+type GameTypeStatistics struct {
+	GameDate    string `json:"date" bson:"date"`
+	GameType    int8   `json:"game_type" bson:"game_type"`
+	GamesPlayed int64  `json:"games_played" bson:"games_played"`
+}
+
+type WinStatusStatistics struct {
+	GameDate    string `json:"date" bson:"date"`
+	WinStatus   int8   `json:"win_status" bson:"win_status"`
+	GamesPlayed int64  `json:"games_played" bson:"games_played"`
+}
+
+// ActivePlayersStatistics counts the distinct players of a day or month.
+type ActivePlayersStatistics struct {
+	GameDate      string `json:"date" bson:"date"`
+	ActivePlayers int64  `json:"active_players" bson:"active_players"`
+}
+
+// GameTypeSummary is the win ratio and points distribution of a game type.
+type GameTypeSummary struct {
+	GameType     int8    `json:"game_type" bson:"game_type"`
+	GamesPlayed  int64   `json:"games_played" bson:"games_played"`
+	Wins         int64   `json:"wins" bson:"wins"`
+	WinRatio     float64 `json:"win_ratio" bson:"win_ratio"`
+	PointsTotal  int64   `json:"points_total" bson:"points_total"`
+	PointsAvg    float64 `json:"points_avg" bson:"points_avg"`
+	PointsMin    int64   `json:"points_min" bson:"points_min"`
+	PointsMax    int64   `json:"points_max" bson:"points_max"`
+	PointsStdDev float64 `json:"points_std_dev" bson:"points_std_dev"`
+}
+
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+	GranularityYear  = "year"
+)
+
+// StatisticsQuery selects the games of a player, or of all players when
+// UserID is empty, in [StartDate, EndDate) and how they are bucketed:
+// by Granularity, in the Location time zone.
+type StatisticsQuery struct {
+	UserID      string
+	StartDate   time.Time
+	EndDate     time.Time
+	Granularity string
+	Location    *time.Location
+}
+
+// Bucket returns the label of the bucket t falls into, formatted the same
+// way as the Mongo $dateToString formats used by the storage.
+func (q StatisticsQuery) Bucket(t time.Time) string {
+	t = t.In(q.Location)
+	switch q.Granularity {
+	case GranularityHour:
+		return t.Format("2006-01-02T15:00")
+	case GranularityWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case GranularityMonth:
+		return t.Format("2006-01")
+	case GranularityYear:
+		return t.Format("2006")
+	}
+	return t.Format("2006-01-02")
+}
+
+// Filter narrows down a games listing, zero values leave a field unrestricted.
+// The created range includes CreatedFrom and excludes CreatedTo.
+type Filter struct {
+	UserID      string
+	GameType    *int8
+	WinStatus   *int8
+	CreatedFrom time.Time
+	CreatedTo   time.Time
 }
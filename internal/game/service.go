@@ -4,27 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"time"
 
 	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var _ Service = &service{}
 
 type Service interface {
 	GetById(ctx context.Context, id string) (Game, error)
-	GetByPlayer(ctx context.Context, uuid string, limit, page int64) ([]Game, error)
-	GetAll(ctx context.Context, limit, page int64) ([]Game, error)
-	GetGamesStatistics(ctx context.Context, userId string, startDate, endDate time.Time) ([]GamesStatistics, error)
+	GetByPlayer(ctx context.Context, uuid string, filter Filter, p pagination.Params) (pagination.Page, error)
+	GetAll(ctx context.Context, filter Filter, p pagination.Params) (pagination.Page, error)
+	GetGamesStatistics(ctx context.Context, query StatisticsQuery) ([]GamesStatistics, error)
+	Create(ctx context.Context, dto CreateGameDTO) (string, error)
 }
 
 type service struct {
 	storage Storage
-	logger  *log.Logger
+	logger  *logging.Logger
 }
 
-func NewService(storage Storage, logger *log.Logger) (Service, error) {
+func NewService(storage Storage, logger *logging.Logger) (Service, error) {
 	return &service{
 		storage: storage,
 		logger:  logger,
@@ -34,7 +36,7 @@ func NewService(storage Storage, logger *log.Logger) (Service, error) {
 func (s service) GetById(ctx context.Context, id string) (game Game, err error) {
 	game, err = s.storage.FindById(ctx, id)
 	if err != nil {
-		if errors.Is(err, apperror.ErrNotFound.Err) {
+		if errors.Is(err, apperror.ErrNotFound) {
 			return game, err
 		}
 		return game, fmt.Errorf("failed to find game by id. error: %w", err)
@@ -42,37 +44,45 @@ func (s service) GetById(ctx context.Context, id string) (game Game, err error)
 	return game, nil
 }
 
-func (s service) GetByPlayer(ctx context.Context, uuid string, limit, page int64) (games []Game, err error) {
-	games, err = s.storage.FindByPlayer(ctx, uuid, limit, page)
-	if err != nil {
-		if errors.Is(err, apperror.ErrNotFound) {
-			return games, err
+func (s service) GetByPlayer(ctx context.Context, uuid string, filter Filter, p pagination.Params) (page pagination.Page, err error) {
+	filter.UserID = uuid
+	return s.GetAll(ctx, filter, p)
+}
+
+func (s service) GetAll(ctx context.Context, filter Filter, p pagination.Params) (page pagination.Page, err error) {
+	if filter.UserID != "" {
+		userId, err := primitive.ObjectIDFromHex(filter.UserID)
+		if err != nil {
+			return page, apperror.ErrInvalidID
 		}
-		return games, fmt.Errorf("failed to get games by player. error: %w", err)
+		filter.UserID = userId.Hex()
 	}
-	if len(games) == 0 {
-		return games, apperror.ErrNotFound
-	}
-	return games, nil
-}
 
-func (s service) GetAll(ctx context.Context, limit, page int64) (games []Game, err error) {
-	games, err = s.storage.FindAll(ctx, limit, page)
+	games, nextCursor, err := s.storage.FindAll(ctx, filter, p)
 	if err != nil {
 		if errors.Is(err, apperror.ErrNotFound) {
-			return games, err
+			return page, err
 		}
-		return games, fmt.Errorf("failed to get all games. error: %w", err)
+		return page, fmt.Errorf("failed to get all games. error: %w", err)
 	}
-	if len(games) == 0 {
-		return games, apperror.ErrNotFound
+
+	total, err := s.storage.Count(ctx, filter)
+	if err != nil {
+		return page, fmt.Errorf("failed to count games. error: %w", err)
 	}
-	return games, nil
+
+	if games == nil {
+		games = []Game{}
+	}
+	return pagination.Page{Items: games, Total: total, NextCursor: nextCursor}, nil
 }
 
-func (s service) GetGamesStatistics(ctx context.Context, userId string, startDate, endDate time.Time) (data []GamesStatistics, err error) {
+func (s service) GetGamesStatistics(ctx context.Context, query StatisticsQuery) (data []GamesStatistics, err error) {
+	if query.UserID != "" && !primitive.IsValidObjectID(query.UserID) {
+		return data, apperror.ErrInvalidID
+	}
 
-	data, err = s.storage.AggregateGamesStatistics(ctx, userId, startDate, endDate)
+	data, err = s.storage.AggregateGamesStatistics(ctx, query)
 	if err != nil {
 		if errors.Is(err, apperror.ErrNotFound) {
 			return data, err
@@ -85,3 +95,17 @@ func (s service) GetGamesStatistics(ctx context.Context, userId string, startDat
 
 	return data, nil
 }
+
+func (s service) Create(ctx context.Context, dto CreateGameDTO) (id string, err error) {
+	if fields := dto.Validate(); fields != nil {
+		return id, apperror.ValidationError(fields)
+	}
+	id, err = s.storage.Create(ctx, dto.toGame())
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return id, err
+		}
+		return id, fmt.Errorf("failed to create game. error: %w", err)
+	}
+	return id, nil
+}
@@ -2,12 +2,18 @@ package game
 
 import (
 	"context"
-	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
 )
 
 type Storage interface {
 	FindById(ctx context.Context, id string) (Game, error)
-	FindByPlayer(ctx context.Context, uuid string, limit, page int64) ([]Game, error)
-	FindAll(ctx context.Context, limit, page int64) ([]Game, error)
-	AggregateGamesStatistics(ctx context.Context, uuid string, startDate, endDate time.Time) ([]GamesStatistics, error)
+	// FindAll returns the games matching filter sorted by created descending,
+	// and the next cursor when p.Keyset is set and more items follow.
+	FindAll(ctx context.Context, filter Filter, p pagination.Params) ([]Game, string, error)
+	// Count returns the number of games matching filter, estimated when the filter is empty.
+	Count(ctx context.Context, filter Filter) (int64, error)
+	AggregateGamesStatistics(ctx context.Context, query StatisticsQuery) ([]GamesStatistics, error)
+	// Create records the game and increments the player's rating as one unit of work.
+	Create(ctx context.Context, game Game) (string, error)
 }
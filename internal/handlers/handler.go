@@ -1,7 +1,9 @@
 package handler
 
-import "net/http"
+import "github.com/IvanKyrylov/user-game-api/pkg/router"
 
+// Handler registers its routes on router, which may be a group under an API
+// prefix such as /api/v1.
 type Handler interface {
-	Register(router *http.ServeMux)
+	Register(router *router.Router)
 }
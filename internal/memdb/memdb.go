@@ -0,0 +1,54 @@
+package memdb
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/IvanKyrylov/user-game-api/internal/game"
+	"github.com/IvanKyrylov/user-game-api/internal/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DB is a process local stand-in for the Mongo database. The in-memory user
+// and game storages share one DB the same way the Mongo storages share
+// collections, so recording a game can bump the player's rating.
+type DB struct {
+	sync.RWMutex
+	Users     map[primitive.ObjectID]*UserDocument
+	Games     []game.Game
+	GameIndex map[primitive.ObjectID]int
+}
+
+// UserDocument is the stored shape of a user, including the denormalized
+// rating and, for accounts, the password hash and sessions.
+type UserDocument struct {
+	user.User
+	Rating       int64
+	PasswordHash string
+	Sessions     []user.Session
+}
+
+func New() *DB {
+	return &DB{
+		Users:     make(map[primitive.ObjectID]*UserDocument),
+		GameIndex: make(map[primitive.ObjectID]int),
+	}
+}
+
+// Less orders object ids the way Mongo sorts them on _id.
+func Less(a, b primitive.ObjectID) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+// Page returns the bounds of the page within a result of total items.
+func Page(total int, limit, page int64) (start, end int) {
+	start = int(page * limit)
+	if start > total {
+		start = total
+	}
+	end = start + int(limit)
+	if limit == 0 || end > total {
+		end = total
+	}
+	return start, end
+}
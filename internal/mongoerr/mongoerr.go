@@ -0,0 +1,35 @@
+package mongoerr
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// Translate maps driver errors to the apperror sentinels, so a storage error
+// reaches the client with the right status. Other errors are returned as is
+// and end up as system errors.
+func Translate(err error) error {
+	var selectionErr topology.ServerSelectionError
+	var hexErr hex.InvalidByteError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return apperror.ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return apperror.ErrConflict
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return apperror.ErrTimeout
+	case errors.Is(err, mongo.ErrClientDisconnected), errors.As(err, &selectionErr), mongo.IsNetworkError(err):
+		return apperror.UnavailableError(err.Error())
+	case errors.Is(err, primitive.ErrInvalidHex), errors.Is(err, hex.ErrLength), errors.As(err, &hexErr):
+		return apperror.ErrInvalidID
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package mongoerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func TestTranslate(t *testing.T) {
+	_, shortHex := primitive.ObjectIDFromHex("abc1")
+	_, badHex := primitive.ObjectIDFromHex("zz")
+	_, oddHex := primitive.ObjectIDFromHex("abc")
+	other := errors.New("other")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil", nil, nil},
+		{"no documents", mongo.ErrNoDocuments, apperror.ErrNotFound},
+		{"wrapped no documents", fmt.Errorf("find: %w", mongo.ErrNoDocuments), apperror.ErrNotFound},
+		{"duplicate key write", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, apperror.ErrConflict},
+		{"duplicate key command", mongo.CommandError{Code: 11000}, apperror.ErrConflict},
+		{"deadline exceeded", context.DeadlineExceeded, apperror.ErrTimeout},
+		{"network timeout", mongo.CommandError{Labels: []string{"NetworkError", "NetworkTimeoutError"}}, apperror.ErrTimeout},
+		{"time limit exceeded", mongo.CommandError{Labels: []string{"ExceededTimeLimitError"}}, apperror.ErrTimeout},
+		{"network", mongo.CommandError{Labels: []string{"NetworkError"}}, apperror.ErrUnavailable},
+		{"server selection", topology.ServerSelectionError{Wrapped: errors.New("no server")}, apperror.ErrUnavailable},
+		{"client disconnected", mongo.ErrClientDisconnected, apperror.ErrUnavailable},
+		{"object id length", shortHex, apperror.ErrInvalidID},
+		{"object id character", badHex, apperror.ErrInvalidID},
+		{"object id odd length", oddHex, apperror.ErrInvalidID},
+		{"other", other, other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Translate(tt.err)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("Translate(%v) = %v, want nil", tt.err, got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("Translate(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+)
+
+// Params describes the requested page. Offset pagination uses Limit and Page,
+// keyset pagination is selected by the presence of the cursor query parameter
+// and continues after the opaque Cursor returned with the previous page.
+type Params struct {
+	Limit  int64
+	Page   int64
+	Cursor string
+	Keyset bool
+}
+
+// Page is one page of a list as returned by the services.
+type Page struct {
+	Items      interface{}
+	Total      int64
+	NextCursor string
+}
+
+// Envelope is the response body of every list endpoint. Next and Prev are
+// links to the neighbour pages, omitted at the ends of the list.
+type Envelope struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	Page       *int64      `json:"page,omitempty"`
+	Limit      int64       `json:"limit"`
+	Next       string      `json:"next,omitempty"`
+	Prev       string      `json:"prev,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+func Parse(r *http.Request) (p Params, err error) {
+	query := r.URL.Query()
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 0 {
+		return p, apperror.BadRequestError("limit query parameter is required positive integers")
+	}
+	p.Limit = int64(limit)
+
+	if _, ok := query["cursor"]; ok {
+		if limit == 0 {
+			return p, apperror.BadRequestError("limit query parameter must be greater than zero with cursor")
+		}
+		p.Keyset = true
+		p.Cursor = query.Get("cursor")
+		return p, nil
+	}
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 0 {
+		return p, apperror.BadRequestError("page query parameter is required positive integers")
+	}
+	p.Page = int64(page)
+
+	return p, nil
+}
+
+func NewEnvelope(r *http.Request, p Params, page Page) Envelope {
+	envelope := Envelope{
+		Items:      page.Items,
+		Total:      page.Total,
+		Limit:      p.Limit,
+		NextCursor: page.NextCursor,
+	}
+
+	if p.Keyset {
+		if page.NextCursor != "" {
+			envelope.Next = link(r, "cursor", page.NextCursor)
+		}
+		return envelope
+	}
+
+	envelope.Page = &p.Page
+	if p.Limit == 0 {
+		return envelope
+	}
+	if (p.Page+1)*p.Limit < page.Total {
+		envelope.Next = link(r, "page", strconv.FormatInt(p.Page+1, 10))
+	}
+	if p.Page > 0 {
+		prev := p.Page - 1
+		if lastPage := (page.Total - 1) / p.Limit; prev > lastPage {
+			prev = lastPage
+		}
+		if prev >= 0 {
+			envelope.Prev = link(r, "page", strconv.FormatInt(prev, 10))
+		}
+	}
+	return envelope
+}
+
+// link returns the request URL with the key query parameter replaced by value.
+func link(r *http.Request, key, value string) string {
+	query := r.URL.Query()
+	query.Set(key, value)
+	return r.URL.Path + "?" + query.Encode()
+}
+
+// EncodeCursor packs the sort key of the last returned item into an opaque cursor.
+func EncodeCursor(v interface{}) (string, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// DecodeCursor unpacks a cursor produced by EncodeCursor into v.
+func DecodeCursor(cursor string, v interface{}) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return apperror.BadRequestError("cursor query parameter is invalid")
+	}
+	if err = json.Unmarshal(bytes, v); err != nil {
+		return apperror.BadRequestError("cursor query parameter is invalid")
+	}
+	return nil
+}
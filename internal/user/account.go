@@ -0,0 +1,224 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/auth"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ AccountService = &accountService{}
+
+// AccountService manages the player accounts: sign-up and login issue an
+// access token of auth.RolePlayer together with a refresh token.
+type AccountService interface {
+	SignUp(ctx context.Context, dto SignUpDTO) (string, Tokens, error)
+	Login(ctx context.Context, dto LoginDTO) (Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (Tokens, error)
+	Logout(ctx context.Context, refreshToken string) error
+	GetAccount(ctx context.Context, uuid string) (User, error)
+	ChangePassword(ctx context.Context, uuid string, dto PasswordDTO) error
+}
+
+// Tokens are issued at sign-up, login and refresh. ExpiresIn is the lifetime
+// of the access token in seconds.
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type accountService struct {
+	storage    Storage
+	issuer     *auth.Issuer
+	refreshTTL time.Duration
+	// dummyHash is compared against on unknown emails, so a login takes as
+	// long for a missing account as for a wrong password.
+	dummyHash []byte
+	logger    *logging.Logger
+}
+
+func NewAccountService(storage Storage, issuer *auth.Issuer, refreshTTL time.Duration, logger *logging.Logger) (AccountService, error) {
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password. error: %w", err)
+	}
+	return &accountService{
+		storage:    storage,
+		issuer:     issuer,
+		refreshTTL: refreshTTL,
+		dummyHash:  dummyHash,
+		logger:     logger,
+	}, nil
+}
+
+func (s accountService) SignUp(ctx context.Context, dto SignUpDTO) (uuid string, tokens Tokens, err error) {
+	if fields := dto.Validate(); fields != nil {
+		return uuid, tokens, apperror.ValidationError(fields)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(dto.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return uuid, tokens, fmt.Errorf("failed to hash password. error: %w", err)
+	}
+	uuid, err = s.storage.CreateAccount(ctx, Account{User: dto.toUser(), PasswordHash: string(hash)})
+	if err != nil {
+		if errors.Is(err, apperror.ErrConflict) {
+			return uuid, tokens, apperror.ConflictError("an account with this email already exists")
+		}
+		return uuid, tokens, fmt.Errorf("failed to create account. error: %w", err)
+	}
+	s.logger.WithContext(ctx).Info("account created", "uuid", uuid)
+
+	tokens, err = s.startSession(ctx, uuid)
+	return uuid, tokens, err
+}
+
+func (s accountService) Login(ctx context.Context, dto LoginDTO) (tokens Tokens, err error) {
+	account, err := s.storage.FindAccount(ctx, dto.Email)
+	if err != nil {
+		if !errors.Is(err, apperror.ErrNotFound) {
+			return tokens, fmt.Errorf("failed to find account. error: %w", err)
+		}
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(dto.Password))
+		s.logger.WithContext(ctx).Warn("login failed, no account")
+		return tokens, apperror.UnauthorizedError("email or password is incorrect")
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(dto.Password)); err != nil {
+		s.logger.WithContext(ctx).Warn("login failed, wrong password", "uuid", account.UUID.Hex())
+		return tokens, apperror.UnauthorizedError("email or password is incorrect")
+	}
+	return s.startSession(ctx, account.UUID.Hex())
+}
+
+func (s accountService) Refresh(ctx context.Context, refreshToken string) (tokens Tokens, err error) {
+	uuid, id, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return tokens, apperror.UnauthorizedError("refresh token is invalid")
+	}
+
+	// The used refresh token is replaced, so it can not be used twice.
+	token, session, err := s.newSession()
+	if err != nil {
+		return tokens, err
+	}
+	if err = s.storage.ReplaceSession(ctx, uuid, id, session); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) || errors.Is(err, apperror.ErrInvalidID) {
+			s.logger.WithContext(ctx).Warn("refresh token rejected", "uuid", uuid)
+			return tokens, apperror.UnauthorizedError("refresh token is invalid or expired")
+		}
+		return tokens, fmt.Errorf("failed to replace session. error: %w", err)
+	}
+	return s.tokens(uuid, token)
+}
+
+func (s accountService) Logout(ctx context.Context, refreshToken string) error {
+	uuid, id, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return apperror.UnauthorizedError("refresh token is invalid")
+	}
+	if err := s.storage.DeleteSession(ctx, uuid, id); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) || errors.Is(err, apperror.ErrInvalidID) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete session. error: %w", err)
+	}
+	return nil
+}
+
+func (s accountService) GetAccount(ctx context.Context, uuid string) (user User, err error) {
+	account, err := s.storage.FindAccountById(ctx, uuid)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) || errors.Is(err, apperror.ErrInvalidID) {
+			return user, err
+		}
+		return user, fmt.Errorf("failed to find account. error: %w", err)
+	}
+	return account.User, nil
+}
+
+func (s accountService) ChangePassword(ctx context.Context, uuid string, dto PasswordDTO) error {
+	if fields := dto.Validate(); fields != nil {
+		return apperror.ValidationError(fields)
+	}
+
+	account, err := s.storage.FindAccountById(ctx, uuid)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) || errors.Is(err, apperror.ErrInvalidID) {
+			return err
+		}
+		return fmt.Errorf("failed to find account. error: %w", err)
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(dto.CurrentPassword)); err != nil {
+		return apperror.ValidationError(map[string]string{"current_password": "is incorrect"})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(dto.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password. error: %w", err)
+	}
+	if err = s.storage.SetPassword(ctx, uuid, string(hash)); err != nil {
+		return fmt.Errorf("failed to set password. error: %w", err)
+	}
+	s.logger.WithContext(ctx).Info("password changed", "uuid", uuid)
+	return nil
+}
+
+func (s accountService) startSession(ctx context.Context, uuid string) (tokens Tokens, err error) {
+	token, session, err := s.newSession()
+	if err != nil {
+		return tokens, err
+	}
+	if err = s.storage.AddSession(ctx, uuid, session); err != nil {
+		return tokens, fmt.Errorf("failed to add session. error: %w", err)
+	}
+	return s.tokens(uuid, token)
+}
+
+func (s accountService) tokens(uuid, refreshToken string) (tokens Tokens, err error) {
+	accessToken, err := s.issuer.Issue(uuid, auth.RolePlayer)
+	if err != nil {
+		return tokens, fmt.Errorf("failed to issue access token. error: %w", err)
+	}
+	return Tokens{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.issuer.TTL().Seconds()),
+		RefreshToken: uuid + "." + refreshToken,
+	}, nil
+}
+
+// newSession returns a random refresh token secret and its session. The
+// refresh token handed out is the account uuid and the secret joined by a dot.
+func (s accountService) newSession() (secret string, session Session, err error) {
+	bytes := make([]byte, 32)
+	if _, err = rand.Read(bytes); err != nil {
+		return secret, session, fmt.Errorf("failed to generate refresh token. error: %w", err)
+	}
+	secret = base64.RawURLEncoding.EncodeToString(bytes)
+	return secret, Session{ID: sessionID(secret), ExpiresAt: time.Now().Add(s.refreshTTL)}, nil
+}
+
+func parseRefreshToken(token string) (uuid, id string, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return uuid, id, false
+	}
+	return parts[0], sessionID(parts[1]), true
+}
+
+func sessionID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
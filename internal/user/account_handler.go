@@ -0,0 +1,192 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/auth"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"github.com/IvanKyrylov/user-game-api/pkg/router"
+)
+
+// AuthPath is the prefix of the routes issuing tokens, which are served
+// without authentication.
+const AuthPath = "/auth"
+
+// Paths are relative to the API prefix the handler is registered under.
+const (
+	signUpURL   = AuthPath + "/signup"
+	loginURL    = AuthPath + "/login"
+	refreshURL  = AuthPath + "/refresh"
+	logoutURL   = AuthPath + "/logout"
+	accountURL  = "/account"
+	passwordURL = "/account/password"
+)
+
+type AccountHandler struct {
+	Logger         *logging.Logger
+	AccountService AccountService
+}
+
+// Register registers the routes of the logged in player.
+func (h *AccountHandler) Register(router *router.Router) {
+	router.HandleFunc(http.MethodGet, accountURL, apperror.Middleware(h.GetAccount))
+	router.HandleFunc(http.MethodPut, passwordURL, apperror.Middleware(h.ChangePassword))
+}
+
+// RegisterPublic registers the routes under AuthPath.
+func (h *AccountHandler) RegisterPublic(router *router.Router) {
+	router.HandleFunc(http.MethodPost, signUpURL, apperror.Middleware(h.SignUp))
+	router.HandleFunc(http.MethodPost, loginURL, apperror.Middleware(h.Login))
+	router.HandleFunc(http.MethodPost, refreshURL, apperror.Middleware(h.Refresh))
+	router.HandleFunc(http.MethodPost, logoutURL, apperror.Middleware(h.Logout))
+}
+
+func (h *AccountHandler) SignUp(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("sign up")
+	w.Header().Set("Content-Type", "application/json")
+
+	var dto SignUpDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("request body must be a valid sign up JSON object")
+	}
+
+	_, tokens, err := h.AccountService.SignUp(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	return writeTokens(w, http.StatusCreated, tokens)
+}
+
+func (h *AccountHandler) Login(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("login")
+	w.Header().Set("Content-Type", "application/json")
+
+	var dto LoginDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("request body must be a valid login JSON object")
+	}
+
+	tokens, err := h.AccountService.Login(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	return writeTokens(w, http.StatusOK, tokens)
+}
+
+func (h *AccountHandler) Refresh(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("refresh")
+	w.Header().Set("Content-Type", "application/json")
+
+	dto, err := h.decodeRefresh(r)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := h.AccountService.Refresh(r.Context(), dto.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return writeTokens(w, http.StatusOK, tokens)
+}
+
+func (h *AccountHandler) Logout(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("logout")
+	w.Header().Set("Content-Type", "application/json")
+
+	dto, err := h.decodeRefresh(r)
+	if err != nil {
+		return err
+	}
+
+	if err = h.AccountService.Logout(r.Context(), dto.RefreshToken); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *AccountHandler) decodeRefresh(r *http.Request) (dto RefreshDTO, err error) {
+	defer r.Body.Close()
+	if err = json.NewDecoder(r.Body).Decode(&dto); err != nil || dto.RefreshToken == "" {
+		return dto, apperror.BadRequestError("request body must be a JSON object with refresh_token")
+	}
+	return dto, nil
+}
+
+func (h *AccountHandler) GetAccount(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("get account")
+	w.Header().Set("Content-Type", "application/json")
+
+	uuid, err := player(r)
+	if err != nil {
+		return err
+	}
+
+	// The player's own profile is not redacted.
+	user, err := h.AccountService.GetAccount(r.Context(), uuid)
+	if err != nil {
+		return err
+	}
+
+	userBytes, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(userBytes)
+	return nil
+}
+
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("change password")
+	w.Header().Set("Content-Type", "application/json")
+
+	uuid, err := player(r)
+	if err != nil {
+		return err
+	}
+
+	var dto PasswordDTO
+	defer r.Body.Close()
+	if err = json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("request body must be a valid password JSON object")
+	}
+
+	if err = h.AccountService.ChangePassword(r.Context(), uuid, dto); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// player returns the account uuid of a caller authenticated with a player token.
+func player(r *http.Request) (string, error) {
+	identity, ok := auth.FromContext(r.Context())
+	if !ok || identity.Role != auth.RolePlayer {
+		return "", apperror.ForbiddenError("a player access token is required")
+	}
+	return identity.Subject, nil
+}
+
+func writeTokens(w http.ResponseWriter, status int, tokens Tokens) error {
+	tokensBytes, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	// Tokens must not be kept by caches.
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(tokensBytes)
+	return nil
+}
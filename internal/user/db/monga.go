@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"regexp"
 	"time"
 
 	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/mongoerr"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
 	"github.com/IvanKyrylov/user-game-api/internal/user"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,12 +20,14 @@ import (
 
 type db struct {
 	collection *mongo.Collection
-	logger     *log.Logger
+	games      *mongo.Collection
+	logger     *logging.Logger
 }
 
-func NewStorage(storage *mongo.Database, collection string, logger *log.Logger) user.Storage {
+func NewStorage(storage *mongo.Database, collection, gamesCollection string, logger *logging.Logger) user.Storage {
 	return &db{
 		collection: storage.Collection(collection),
+		games:      storage.Collection(gamesCollection),
 		logger:     logger,
 	}
 }
@@ -30,137 +35,773 @@ func NewStorage(storage *mongo.Database, collection string, logger *log.Logger)
 func (s *db) FindById(ctx context.Context, uuid string) (user user.User, err error) {
 
 	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return user, apperror.ErrInvalidID
+	}
 	filter := bson.M{"_id": userId}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	result := s.collection.FindOne(ctx, filter)
 
-	if result.Err() != nil {
-		s.logger.Println(result.Err())
-		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-			return user, apperror.ErrNotFound
-		}
-		return user, fmt.Errorf("failed to execute query. error: %w", err)
+	if err = result.Err(); err != nil {
+		s.logger.WithContext(ctx).Debug("failed to find user", "uuid", uuid, "error", err)
+		return user, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
 	}
 
 	if err = result.Decode(&user); err != nil {
-		return user, fmt.Errorf("failed to decode document. error: %w", err)
+		return user, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
 	}
 	return user, nil
 
 }
 
-func (s *db) FindByName(ctx context.Context, lastName string) (user user.User, err error) {
+func (s *db) Search(ctx context.Context, query user.SearchQuery, p pagination.Params) (results []user.SearchResult, total int64, err error) {
+	if !query.IncludeEmail {
+		return s.searchWithoutEmail(ctx, query.Text, p)
+	}
 
-	filter := bson.M{"last_name": lastName}
+	filter := bson.M{"$text": bson.M{"$search": query.Text}}
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{"score", score}, {"_id", 1}}).
+		SetSkip(p.Page * p.Limit).
+		SetLimit(p.Limit)
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	result := s.collection.FindOne(ctx, filter)
 
-	if result.Err() != nil {
-		s.logger.Println(result.Err())
-		if errors.Is(result.Err(), mongo.ErrNoDocuments) {
-			return user, apperror.ErrNotFound
-		}
-		return user, fmt.Errorf("failed to execute query. error: %w", err)
+	cur, err := s.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return results, total, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
 	}
 
-	if err = result.Decode(&user); err != nil {
-		return user, fmt.Errorf("failed to decode document. error: %w", err)
+	var documents []struct {
+		user.User `bson:",inline"`
+		Score     float64 `bson:"score"`
 	}
-	return user, nil
+	if err = cur.All(ctx, &documents); err != nil {
+		return results, total, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+
+	total, err = s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return results, total, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	for _, document := range documents {
+		results = append(results, user.SearchResult{
+			User:  document.User,
+			Score: document.Score,
+		})
+	}
+	return results, total, nil
 }
 
-func (s *db) FindAll(ctx context.Context, limit, page int64) (users []user.User, err error) {
+// searchWithoutEmail narrows the users with the text index, which also covers
+// the email, then scores the query words found in the other fields with the
+// weights of the index and drops the users only the email matched.
+//
+// The words are matched exactly, while the text index also matches their
+// stemmed forms, e.g. players finds player. Such users are dropped here, so
+// callers without the pii scope may get fewer users, ordered by these weights
+// rather than the text score.
+func (s *db) searchWithoutEmail(ctx context.Context, q string, p pagination.Params) (results []user.SearchResult, total int64, err error) {
+	pipeline := searchWithoutEmailPipeline(q, p)
+	if pipeline == nil {
+		return results, total, nil
+	}
 
-	cur, err := s.collection.Find(ctx, bson.M{}, options.Find().SetLimit(limit).SetSkip(page*limit))
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
+	cur, err := s.collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return users, apperror.ErrNotFound
+		return results, total, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	var result []struct {
+		Items []struct {
+			user.User `bson:",inline"`
+			Score     float64 `bson:"score"`
+		} `bson:"items"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if err = cur.All(ctx, &result); err != nil {
+		return results, total, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+	if len(result) == 0 {
+		return results, total, nil
+	}
+
+	if len(result[0].Total) > 0 {
+		total = result[0].Total[0].Count
+	}
+	for _, item := range result[0].Items {
+		results = append(results, user.SearchResult{
+			User:  item.User,
+			Score: item.Score,
+		})
+	}
+	return results, total, nil
+}
+
+// searchWithoutEmailPipeline returns the aggregation of searchWithoutEmail,
+// nil when q has no words.
+func searchWithoutEmailPipeline(q string, p pagination.Params) mongo.Pipeline {
+	fields := []struct {
+		name   string
+		weight int
+	}{
+		{"last_name", 10},
+		{"city", 2},
+		{"country", 1},
+	}
+	var terms bson.A
+	for _, token := range user.SearchTokens(q) {
+		word := `(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(token) + `([^\p{L}\p{N}]|$)`
+		for _, field := range fields {
+			terms = append(terms, bson.M{"$cond": bson.A{
+				bson.M{"$regexMatch": bson.M{"input": "$" + field.name, "regex": word, "options": "i"}},
+				field.weight,
+				0,
+			}})
 		}
-		return users, fmt.Errorf("failed to execute query. error: %w", err)
 	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	itemsStages := []bson.D{
+		{{"$sort", bson.D{{"score", -1}, {"_id", 1}}}},
+		{{"$skip", p.Page * p.Limit}},
+	}
+	// $limit must be positive, a zero limit returns every user
+	if p.Limit > 0 {
+		itemsStages = append(itemsStages, bson.D{{"$limit", p.Limit}})
+	}
+	return mongo.Pipeline{
+		{{"$match", bson.M{"$text": bson.M{"$search": q}}}},
+		{{"$addFields", bson.M{"score": bson.M{"$add": terms}}}},
+		{{"$match", bson.M{"score": bson.M{"$gt": 0}}}},
+		{{"$facet", bson.M{
+			"items": itemsStages,
+			"total": []bson.D{{{"$count", "count"}}},
+		}}},
+	}
+}
+
+type idCursor struct {
+	ID primitive.ObjectID `json:"id"`
+}
+
+type ratingCursor struct {
+	Rating int64              `json:"rating"`
+	ID     primitive.ObjectID `json:"id"`
+}
+
+func filterQuery(filter user.Filter) bson.M {
+	query := bson.M{}
 
-	if err = cur.All(ctx, &users); err == nil {
-		return users, nil
+	if filter.LastName != "" {
+		query["last_name"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(filter.LastName), Options: "i"}
+	}
+	if filter.Email != "" {
+		query["email"] = filter.Email
+	}
+	if filter.Country != "" {
+		query["country"] = filter.Country
+	}
+	if filter.City != "" {
+		query["city"] = filter.City
+	}
+	if filter.Gender != "" {
+		query["gender"] = filter.Gender
+	}
+
+	birthDate := bson.M{}
+	if !filter.BornFrom.IsZero() {
+		birthDate["$gte"] = primitive.NewDateTimeFromTime(filter.BornFrom)
+	}
+	if !filter.BornTo.IsZero() {
+		birthDate["$lt"] = primitive.NewDateTimeFromTime(filter.BornTo)
+	}
+	if len(birthDate) > 0 {
+		query["birth_date"] = birthDate
 	}
-	return users, fmt.Errorf("failed to decode document. error: %w", err)
+
+	return query
 }
 
-func (s *db) AggregateRatingUsers(ctx context.Context, limit, page int64) (usersRatings []user.UserRating, err error) {
-	skip := page * limit
+func (s *db) FindAll(ctx context.Context, filter user.Filter, sort user.Sort, p pagination.Params) (users []user.User, nextCursor string, err error) {
+
+	query := filterQuery(filter)
+	findOptions := options.Find().SetLimit(p.Limit)
+	if sort.Field != "" {
+		direction := 1
+		if sort.Desc {
+			direction = -1
+		}
+		findOptions.SetSort(bson.D{{sort.Field, direction}, {"_id", 1}})
+	}
+
+	if p.Keyset {
+		findOptions.SetSort(bson.D{{"_id", 1}}).SetLimit(p.Limit + 1)
+		if p.Cursor != "" {
+			var cursor idCursor
+			if err = pagination.DecodeCursor(p.Cursor, &cursor); err != nil {
+				return users, nextCursor, err
+			}
+			query["_id"] = bson.M{"$gt": cursor.ID}
+		}
+	} else {
+		findOptions.SetSkip(p.Page * p.Limit)
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// pipeline := make([]bson.M, 0)
-	// groupStage := bson.M{
-	// 	"$group": bson.M{
-	// 		"_id":        "$user_id",
-	// 		"count_game": bson.M{"$sum": 1},
-	// 	},
-	// }
-
-	// pipeline = append(pipeline, groupStage)
-
-	// pipeline := []bson.M{
-	// 	{
-	// 		"$group": bson.M{
-	// 			"_id":         "$user_id",
-	// 			"count_games": bson.M{"$sum": 1},
-	// 		},
-	// 	},
-	// 	{
-	// 		"$limit": limit,
-	// 	},
-	// 	{
-	// 		"$skip": skip,
-	// 	},
-	// }
-
-	// id, _ := primitive.ObjectIDFromHex("60c0928d9bfeb2397e77124d")
-	// matchStage := bson.D{{"$match", bson.D{{"user_id", id}}}}
-
-	// skipStage := bson.D{{"$skip", skip}}
-	// limitStage := bson.D{{"$limit", limit}}
-	// groupStage := bson.D{{"$group", bson.D{{"_id", "$user_id"}, {"count_games", bson.D{{"$sum", 1}}}}}}
-	// sortStage := bson.D{{"$sort", bson.D{{"count_game", -1}}}}
-
-	cur, err := s.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{"rating", -1}}).SetLimit(limit).SetSkip(skip))
-	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return usersRatings, apperror.ErrNotFound
+	cur, err := s.collection.Find(ctx, query, findOptions)
+	if err != nil {
+		return users, nextCursor, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	if err = cur.All(ctx, &users); err != nil {
+		return users, nextCursor, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+
+	if p.Keyset && int64(len(users)) > p.Limit {
+		users = users[:p.Limit]
+		nextCursor, err = pagination.EncodeCursor(idCursor{ID: users[len(users)-1].UUID})
+		if err != nil {
+			return users, nextCursor, fmt.Errorf("failed to encode cursor. error: %w", err)
 		}
-		return usersRatings, fmt.Errorf("failed to execute query. error: %w", err)
 	}
+	return users, nextCursor, nil
+}
 
-	for cur.Next(ctx) {
-		var elem bson.M
-		err := cur.Decode(&elem)
+func (s *db) AggregateRatingUsers(ctx context.Context, p pagination.Params) (usersRatings []user.UserRating, nextCursor string, err error) {
+
+	filter := bson.M{}
+	findOptions := options.Find().SetSort(bson.D{{"rating", -1}, {"_id", 1}}).SetLimit(p.Limit)
+
+	if p.Keyset {
+		findOptions.SetLimit(p.Limit + 1)
+		if p.Cursor != "" {
+			var cursor ratingCursor
+			if err = pagination.DecodeCursor(p.Cursor, &cursor); err != nil {
+				return usersRatings, nextCursor, err
+			}
+			filter["$or"] = []bson.M{
+				{"rating": bson.M{"$lt": cursor.Rating}},
+				{"rating": cursor.Rating, "_id": bson.M{"$gt": cursor.ID}},
+			}
+		}
+	} else {
+		findOptions.SetSkip(p.Page * p.Limit)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cur, err := s.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return usersRatings, nextCursor, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	var documents []userDocument
+	if err = cur.All(ctx, &documents); err != nil {
+		return usersRatings, nextCursor, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+
+	if p.Keyset && int64(len(documents)) > p.Limit {
+		documents = documents[:p.Limit]
+		last := documents[len(documents)-1]
+		nextCursor, err = pagination.EncodeCursor(ratingCursor{Rating: last.Rating, ID: last.UUID})
 		if err != nil {
-			return usersRatings, fmt.Errorf("failed to decode document. error: %w", err)
+			return usersRatings, nextCursor, fmt.Errorf("failed to encode cursor. error: %w", err)
 		}
+	}
+
+	for _, document := range documents {
+		usersRatings = append(usersRatings, user.UserRating{
+			User:   document.User,
+			Rating: document.Rating,
+		})
+	}
+	return usersRatings, nextCursor, nil
+}
+
+func (s *db) AggregateUserStats(ctx context.Context, uuid string) (stats user.UserStats, err error) {
+
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return stats, apperror.ErrInvalidID
+	}
+
+	pipeline := []bson.D{
+		{{"$match", bson.M{"user_id": userId}}},
+		{{"$facet", bson.M{
+			"totals": []bson.D{
+				{{"$group", bson.M{
+					"_id":                   nil,
+					"total_games":           bson.M{"$sum": 1},
+					"wins":                  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$win_status", 1}}, 1, 0}}},
+					"total_points_gained":   bson.M{"$sum": "$points_gained"},
+					"average_points_gained": bson.M{"$avg": "$points_gained"},
+					"last_played":           bson.M{"$max": "$created"},
+				}}},
+			},
+			"game_types": []bson.D{
+				{{"$group", bson.M{
+					"_id":   "$game_type",
+					"games": bson.M{"$sum": 1},
+				}}},
+				{{"$sort", bson.D{{"games", -1}, {"_id", 1}}}},
+				{{"$limit", 1}},
+			},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cur, err := s.games.Aggregate(ctx, pipeline)
+	if err != nil {
+		return stats, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	var result []struct {
+		Totals []struct {
+			TotalGames          int64     `bson:"total_games"`
+			Wins                int64     `bson:"wins"`
+			TotalPointsGained   int64     `bson:"total_points_gained"`
+			AveragePointsGained float64   `bson:"average_points_gained"`
+			LastPlayed          time.Time `bson:"last_played"`
+		} `bson:"totals"`
+		GameTypes []struct {
+			GameType int8 `bson:"_id"`
+		} `bson:"game_types"`
+	}
+	if err = cur.All(ctx, &result); err != nil {
+		return stats, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+	if len(result) == 0 || len(result[0].Totals) == 0 {
+		return stats, nil
+	}
 
-		userField := user.User{
-			UUID:      elem["_id"].(primitive.ObjectID),
-			Email:     elem["email"].(string),
-			LastName:  elem["last_name"].(string),
-			Country:   elem["country"].(string),
-			City:      elem["city"].(string),
-			Gender:    elem["gender"].(string),
-			BirthDate: elem["birth_date"].(primitive.DateTime),
+	totals := result[0].Totals[0]
+	stats = user.UserStats{
+		TotalGames:        totals.TotalGames,
+		Wins:              totals.Wins,
+		Losses:            totals.TotalGames - totals.Wins,
+		WinRate:           float64(totals.Wins) / float64(totals.TotalGames),
+		TotalPointsGained: totals.TotalPointsGained,
+		AvgPointsGained:   totals.AveragePointsGained,
+		LastPlayed:        &totals.LastPlayed,
+	}
+	if len(result[0].GameTypes) > 0 {
+		stats.FavouriteGameType = result[0].GameTypes[0].GameType
+	}
+	return stats, nil
+}
+
+// leaderboardSort is the leaderboard order for each metric, ties go to the older user.
+var leaderboardSort = map[string]bson.D{
+	user.MetricGames:   {{"games", -1}, {"_id", 1}},
+	user.MetricPoints:  {{"points", -1}, {"_id", 1}},
+	user.MetricWins:    {{"wins", -1}, {"_id", 1}},
+	user.MetricWinRate: {{"win_rate", -1}, {"games", -1}, {"_id", 1}},
+}
+
+func (s *db) AggregateLeaderboard(ctx context.Context, query user.LeaderboardQuery, p pagination.Params) (entries []user.LeaderboardEntry, total int64, err error) {
+
+	match := bson.M{}
+	if query.GameType != nil {
+		match["game_type"] = *query.GameType
+	}
+	if !query.Since.IsZero() {
+		match["created"] = bson.M{"$gte": query.Since}
+	}
+
+	sort, ok := leaderboardSort[query.Metric]
+	if !ok {
+		sort = leaderboardSort[user.MetricGames]
+	}
+
+	lookupStages := []bson.D{
+		{{"$lookup", bson.M{
+			"from":         s.collection.Name(),
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{"$unwind", "$user"}},
+	}
+
+	pipeline := []bson.D{
+		{{"$match", match}},
+		{{"$group", bson.M{
+			"_id":    "$user_id",
+			"games":  bson.M{"$sum": int64(1)},
+			"wins":   bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$win_status", 1}}, 1, 0}}},
+			"points": bson.M{"$sum": "$points_gained"},
+		}}},
+		{{"$addFields", bson.M{
+			"win_rate": bson.M{"$divide": bson.A{"$wins", "$games"}},
+		}}},
+	}
+
+	itemsStages := []bson.D{
+		{{"$sort", sort}},
+		{{"$skip", p.Page * p.Limit}},
+	}
+	if p.Limit > 0 {
+		itemsStages = append(itemsStages, bson.D{{"$limit", p.Limit}})
+	}
+
+	// The users are joined before counting and paging, so games of deleted
+	// users, dropped by $unwind, neither take ranks nor count in the total.
+	pipeline = append(pipeline, lookupStages...)
+	if query.Country != "" || query.City != "" {
+		location := bson.M{}
+		if query.Country != "" {
+			location["user.country"] = query.Country
+		}
+		if query.City != "" {
+			location["user.city"] = query.City
 		}
+		pipeline = append(pipeline, bson.D{{"$match", location}})
+	}
+
+	pipeline = append(pipeline, bson.D{{"$facet", bson.M{
+		"items": itemsStages,
+		"total": []bson.D{{{"$count", "count"}}},
+	}}})
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	cur, err := s.games.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return entries, total, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	var result []struct {
+		Items []struct {
+			User    user.User `bson:"user"`
+			Games   int64     `bson:"games"`
+			Wins    int64     `bson:"wins"`
+			Points  int64     `bson:"points"`
+			WinRate float64   `bson:"win_rate"`
+		} `bson:"items"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if err = cur.All(ctx, &result); err != nil {
+		return entries, total, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+	if len(result) == 0 {
+		return entries, total, nil
+	}
+
+	if len(result[0].Total) > 0 {
+		total = result[0].Total[0].Count
+	}
+	for i, item := range result[0].Items {
+		entries = append(entries, user.LeaderboardEntry{
+			Rank:    p.Page*p.Limit + int64(i) + 1,
+			User:    item.User,
+			Games:   item.Games,
+			Wins:    item.Wins,
+			Points:  item.Points,
+			WinRate: item.WinRate,
+		})
+	}
+	return entries, total, nil
+}
+
+func (s *db) FindRank(ctx context.Context, uuid string, neighbours int64) (rank user.UserRank, err error) {
+
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return rank, apperror.ErrInvalidID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var document userDocument
+	if err = s.collection.FindOne(ctx, bson.M{"_id": userId}).Decode(&document); err != nil {
+		return rank, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	// Users ahead of the document in the {rating: -1, _id: 1} order, both
+	// filters are served by the rating index.
+	above := bson.M{"$or": []bson.M{
+		{"rating": bson.M{"$gt": document.Rating}},
+		{"rating": document.Rating, "_id": bson.M{"$lt": document.UUID}},
+	}}
+	below := bson.M{"$or": []bson.M{
+		{"rating": bson.M{"$lt": document.Rating}},
+		{"rating": document.Rating, "_id": bson.M{"$gt": document.UUID}},
+	}}
+
+	higher, err := s.collection.CountDocuments(ctx, above)
+	if err != nil {
+		return rank, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+	rank = user.UserRank{
+		Rank:   higher + 1,
+		User:   document.User,
+		Rating: document.Rating,
+	}
+	if neighbours == 0 {
+		return rank, nil
+	}
+
+	var aboveDocuments, belowDocuments []userDocument
+	cur, err := s.collection.Find(ctx, above, options.Find().SetSort(bson.D{{"rating", 1}, {"_id", -1}}).SetLimit(neighbours))
+	if err != nil {
+		return rank, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+	if err = cur.All(ctx, &aboveDocuments); err != nil {
+		return rank, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+	cur, err = s.collection.Find(ctx, below, options.Find().SetSort(bson.D{{"rating", -1}, {"_id", 1}}).SetLimit(neighbours))
+	if err != nil {
+		return rank, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+	if err = cur.All(ctx, &belowDocuments); err != nil {
+		return rank, fmt.Errorf("failed to decode document. error: %w", mongoerr.Translate(err))
+	}
+
+	// aboveDocuments are fetched nearest first, the response lists them in rating order.
+	for i := len(aboveDocuments) - 1; i >= 0; i-- {
+		rank.Above = append(rank.Above, user.UserRating{
+			Rank:   rank.Rank - int64(i) - 1,
+			User:   aboveDocuments[i].User,
+			Rating: aboveDocuments[i].Rating,
+		})
+	}
+	for i, document := range belowDocuments {
+		rank.Below = append(rank.Below, user.UserRating{
+			Rank:   rank.Rank + int64(i) + 1,
+			User:   document.User,
+			Rating: document.Rating,
+		})
+	}
+	return rank, nil
+}
+
+func (s *db) Count(ctx context.Context, filter user.Filter) (count int64, err error) {
+
+	query := filterQuery(filter)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if len(query) == 0 {
+		count, err = s.collection.EstimatedDocumentCount(ctx)
+	} else {
+		count, err = s.collection.CountDocuments(ctx, query)
+	}
+	if err != nil {
+		return count, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+	return count, nil
+}
+
+// userDocument is the stored shape of a user, including the denormalized rating.
+type userDocument struct {
+	user.User `bson:",inline"`
+	Rating    int64 `bson:"rating"`
+}
+
+func (s *db) Create(ctx context.Context, user user.User) (uuid string, err error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.collection.InsertOne(ctx, userDocument{User: user})
+	if err != nil {
+		return uuid, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	objectId, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return uuid, fmt.Errorf("failed to convert inserted id %v to objectid", result.InsertedID)
+	}
+	return objectId.Hex(), nil
+}
+
+func (s *db) Update(ctx context.Context, user user.User) error {
+
+	filter := bson.M{"_id": user.UUID}
+
+	user.UUID = primitive.NilObjectID
+	userBytes, err := bson.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user. error: %w", err)
+	}
+
+	var updateUserObj bson.M
+	if err = bson.Unmarshal(userBytes, &updateUserObj); err != nil {
+		return fmt.Errorf("failed to unmarshal user bytes. error: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-		userRating := user.UserRating{
-			User:   userField,
-			Rating: elem["rating"].(int64),
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": updateUserObj})
+	if err != nil {
+		return fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+	if result.MatchedCount == 0 {
+		return apperror.ErrNotFound
+	}
+	return nil
+}
+
+func (s *db) Delete(ctx context.Context, uuid string) error {
+
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidID
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	session, err := s.collection.Database().Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session. error: %w", mongoerr.Translate(err))
+	}
+	defer session.EndSession(ctx)
+
+	// The games go with the user, so that no statistics count orphans.
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		result, err := s.collection.DeleteOne(sessCtx, bson.M{"_id": userId})
+		if err != nil {
+			return nil, err
 		}
-		usersRatings = append(usersRatings, userRating)
+		if result.DeletedCount == 0 {
+			return nil, apperror.ErrNotFound
+		}
+		return s.games.DeleteMany(sessCtx, bson.M{"user_id": userId})
+	})
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to execute transaction. error: %w", mongoerr.Translate(err))
+	}
+	return nil
+}
+
+// accountDocument is an account as stored, with the rating of a new user.
+type accountDocument struct {
+	user.Account `bson:",inline"`
+	Rating       int64 `bson:"rating"`
+}
+
+// hasPassword matches the users who signed up with a password.
+var hasPassword = bson.M{"$exists": true}
+
+func (s *db) CreateAccount(ctx context.Context, account user.Account) (uuid string, err error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// The unique users_account_email index rejects a second account with the email.
+	result, err := s.collection.InsertOne(ctx, accountDocument{Account: account})
+	if err != nil {
+		return uuid, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+
+	objectId, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return uuid, fmt.Errorf("failed to convert inserted id %v to objectid", result.InsertedID)
 	}
+	return objectId.Hex(), nil
+}
+
+func (s *db) FindAccount(ctx context.Context, email string) (account user.Account, err error) {
+	return s.findAccount(ctx, bson.M{"email": email, "password_hash": hasPassword})
+}
 
-	return usersRatings, nil
+func (s *db) FindAccountById(ctx context.Context, uuid string) (account user.Account, err error) {
 
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return account, apperror.ErrInvalidID
+	}
+	return s.findAccount(ctx, bson.M{"_id": userId, "password_hash": hasPassword})
+}
+
+func (s *db) findAccount(ctx context.Context, filter bson.M) (account user.Account, err error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err = s.collection.FindOne(ctx, filter).Decode(&account); err != nil {
+		return account, fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+	return account, nil
+}
+
+func (s *db) SetPassword(ctx context.Context, uuid, hash string) error {
+	return s.updateAccount(ctx, uuid, nil, bson.M{
+		"$set":   bson.M{"password_hash": hash},
+		"$unset": bson.M{"sessions": ""},
+	})
+}
+
+func (s *db) AddSession(ctx context.Context, uuid string, session user.Session) error {
+	return s.updateAccount(ctx, uuid, nil, bson.M{
+		"$push": bson.M{"sessions": bson.M{"$each": []user.Session{session}, "$slice": -user.MaxSessions}},
+	})
+}
+
+func (s *db) ReplaceSession(ctx context.Context, uuid, id string, session user.Session) error {
+	// The positional operator replaces the session matched by $elemMatch, so a
+	// refresh token used twice concurrently is only accepted once.
+	filter := bson.M{"sessions": bson.M{"$elemMatch": bson.M{"id": id, "expires_at": bson.M{"$gt": time.Now()}}}}
+	return s.updateAccount(ctx, uuid, filter, bson.M{"$set": bson.M{"sessions.$": session}})
+}
+
+func (s *db) DeleteSession(ctx context.Context, uuid, id string) error {
+	filter := bson.M{"sessions.id": id}
+	return s.updateAccount(ctx, uuid, filter, bson.M{"$pull": bson.M{"sessions": bson.M{"id": id}}})
+}
+
+// updateAccount applies update to the account uuid matching filter, ErrNotFound
+// when no account matches.
+func (s *db) updateAccount(ctx context.Context, uuid string, filter bson.M, update bson.M) error {
+
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidID
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	filter["_id"] = userId
+	filter["password_hash"] = hasPassword
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to execute query. error: %w", mongoerr.Translate(err))
+	}
+	if result.MatchedCount == 0 {
+		return apperror.ErrNotFound
+	}
+	return nil
 }
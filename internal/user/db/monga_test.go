@@ -0,0 +1,36 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// pagingStages returns the names of the stages of the items facet.
+func pagingStages(t *testing.T, q string, p pagination.Params) []string {
+	t.Helper()
+	pipeline := searchWithoutEmailPipeline(q, p)
+	if len(pipeline) == 0 {
+		t.Fatalf("no pipeline for %q", q)
+	}
+	facet := pipeline[len(pipeline)-1].Map()["$facet"].(bson.M)
+	var names []string
+	for _, stage := range facet["items"].([]bson.D) {
+		names = append(names, stage[0].Key)
+	}
+	return names
+}
+
+func TestSearchWithoutEmailPipeline(t *testing.T) {
+	if got := pagingStages(t, "smith kyiv", pagination.Params{Limit: 10, Page: 2}); len(got) != 3 || got[2] != "$limit" {
+		t.Errorf("stages = %v, want $sort, $skip and $limit", got)
+	}
+	// MongoDB rejects $limit 0, which pagination accepts as every item
+	if got := pagingStages(t, "smith", pagination.Params{}); len(got) != 2 {
+		t.Errorf("stages with limit 0 = %v, want $sort and $skip", got)
+	}
+	if pipeline := searchWithoutEmailPipeline(" @. ", pagination.Params{Limit: 10}); pipeline != nil {
+		t.Errorf("pipeline without words = %v, want nil", pipeline)
+	}
+}
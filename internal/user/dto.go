@@ -0,0 +1,142 @@
+package user
+
+import (
+	"net/mail"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const birthDateLayout = "2006-01-02"
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+var genders = map[string]bool{
+	"Male":   true,
+	"Female": true,
+}
+
+type UserDTO struct {
+	Email     string `json:"email"`
+	LastName  string `json:"last_name"`
+	Country   string `json:"country"`
+	City      string `json:"city"`
+	Gender    string `json:"gender"`
+	BirthDate string `json:"birth_date"`
+}
+
+// Validate checks the DTO fields and returns a message per invalid field.
+// With partial set only the fields present in the DTO are checked.
+func (d UserDTO) Validate(partial bool) map[string]string {
+	fields := make(map[string]string)
+
+	required := func(field, value string) bool {
+		if value != "" {
+			return true
+		}
+		if !partial {
+			fields[field] = "is required"
+		}
+		return false
+	}
+
+	if required("email", d.Email) {
+		if addr, err := mail.ParseAddress(d.Email); err != nil || addr.Address != d.Email {
+			fields["email"] = "must be a valid email address"
+		}
+	}
+	required("last_name", d.LastName)
+	required("country", d.Country)
+	required("city", d.City)
+	if required("gender", d.Gender) && !genders[d.Gender] {
+		fields["gender"] = "must be one of Male, Female"
+	}
+	if required("birth_date", d.BirthDate) {
+		birthDate, err := time.Parse(birthDateLayout, d.BirthDate)
+		if err != nil {
+			fields["birth_date"] = "must be an ISO date in yyyy-mm-dd format"
+		} else if birthDate.After(time.Now()) {
+			fields["birth_date"] = "must not be in the future"
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+func (d UserDTO) toUser() User {
+	user := User{
+		Email:    d.Email,
+		LastName: d.LastName,
+		Country:  d.Country,
+		City:     d.City,
+		Gender:   d.Gender,
+	}
+	if birthDate, err := time.Parse(birthDateLayout, d.BirthDate); err == nil {
+		user.BirthDate = primitive.NewDateTimeFromTime(birthDate)
+	}
+	return user
+}
+
+// SignUpDTO is a new player: the user profile and the password.
+type SignUpDTO struct {
+	UserDTO
+	Password string `json:"password"`
+}
+
+func (d SignUpDTO) Validate() map[string]string {
+	fields := d.UserDTO.Validate(false)
+	if fields == nil {
+		fields = make(map[string]string)
+	}
+	validatePassword(fields, "password", d.Password)
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+type LoginDTO struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type RefreshDTO struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type PasswordDTO struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (d PasswordDTO) Validate() map[string]string {
+	fields := make(map[string]string)
+	if d.CurrentPassword == "" {
+		fields["current_password"] = "is required"
+	}
+	validatePassword(fields, "new_password", d.NewPassword)
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// validatePassword checks the length of a password, bcrypt uses at most 72 bytes.
+func validatePassword(fields map[string]string, field, password string) {
+	switch {
+	case password == "":
+		fields[field] = "is required"
+	case len(password) < minPasswordLength:
+		fields[field] = "must be at least 8 characters long"
+	case len(password) > maxPasswordLength:
+		fields[field] = "must be at most 72 bytes long"
+	}
+}
@@ -2,51 +2,108 @@ package user
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/auth"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"github.com/IvanKyrylov/user-game-api/pkg/router"
 )
 
+// Paths are relative to the API prefix the handler is registered under.
 const (
-	usersURL   = "/api/users"
-	userURL    = "/api/user/"
-	userRating = "/api/users-rating"
+	usersURL        = "/users"
+	searchURL       = "/users/search"
+	userURL         = "/users/{uuid}"
+	userRankURL     = "/users/{uuid}/rank"
+	userRating      = "/users-rating"
+	leaderboardsURL = "/leaderboards"
+
+	// legacyUserURL and legacyUserRankURL are the singular forms the API was
+	// first published with, kept as aliases.
+	legacyUserURL     = "/user/{uuid}"
+	legacyUserRankURL = "/user/{uuid}/rank"
+)
+
+var leaderboardMetrics = map[string]bool{
+	MetricGames:   true,
+	MetricPoints:  true,
+	MetricWins:    true,
+	MetricWinRate: true,
+}
+
+var leaderboardWindows = map[string]bool{
+	WindowDaily:   true,
+	WindowWeekly:  true,
+	WindowAllTime: true,
+}
+
+// piiSortFields are the SortFields only callers with the pii scope may use.
+var piiSortFields = map[string]bool{
+	"email":      true,
+	"birth_date": true,
+}
+
+const (
+	defaultNeighbours = 5
+	maxNeighbours     = 100
 )
 
 type Handler struct {
-	Logger      *log.Logger
+	Logger      *logging.Logger
 	UserService Service
 }
 
-func (h *Handler) Register(router *http.ServeMux) {
-	router.HandleFunc(userURL, apperror.Middleware(h.GetUser))
-	router.HandleFunc(usersURL, apperror.Middleware(h.GetAllUsers))
-	router.HandleFunc(userRating, apperror.Middleware(h.GetUsersRaing))
+func (h *Handler) Register(router *router.Router) {
+	router.HandleFunc(http.MethodGet, usersURL, apperror.Middleware(h.GetAllUsers))
+	router.HandleFunc(http.MethodPost, usersURL, apperror.Middleware(auth.RequireScope(auth.ScopeWrite, h.CreateUser)))
+	router.HandleFunc(http.MethodGet, searchURL, apperror.Middleware(h.SearchUsers))
+	router.HandleFunc(http.MethodGet, userRating, apperror.Middleware(h.GetUsersRaing))
+	router.HandleFunc(http.MethodGet, leaderboardsURL, apperror.Middleware(h.GetLeaderboard))
 
-	// router.HandleFunc(userURL, apperror.Middleware(h.GetUserByName))
+	for _, url := range []string{userURL, legacyUserURL} {
+		router.HandleFunc(http.MethodGet, url, apperror.Middleware(h.GetUser))
+		router.HandleFunc(http.MethodPut, url, apperror.Middleware(auth.RequireScope(auth.ScopeWrite, h.UpdateUser)))
+		router.HandleFunc(http.MethodPatch, url, apperror.Middleware(auth.RequireScope(auth.ScopeWrite, h.PartiallyUpdateUser)))
+		router.HandleFunc(http.MethodDelete, url, apperror.Middleware(auth.RequireScope(auth.ScopeWrite, h.DeleteUser)))
+	}
+	for _, url := range []string{userRankURL, legacyUserRankURL} {
+		router.HandleFunc(http.MethodGet, url, apperror.Middleware(h.GetUserRank))
+	}
 }
 
 func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		return apperror.BadRequestError("metod GET")
-	}
-	h.Logger.Println("GET USER")
+	h.Logger.WithContext(r.Context()).Debug("get user")
 	w.Header().Set("Content-Type", "application/json")
 
-	h.Logger.Println("get uuid from path")
-	uuid := r.URL.Path[len(userURL):]
-	if uuid == "" {
-		return apperror.BadRequestError("uuid query parameter is required and must be a comma separated integers")
-	}
+	uuid := router.Param(r, "uuid")
 
 	user, err := h.UserService.GetById(r.Context(), uuid)
 	if err != nil {
 		return err
 	}
+	view := UserView{User: user}
 
-	userBytes, err := json.Marshal(user)
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		switch include {
+		case "":
+		case "stats":
+			stats, err := h.UserService.GetStats(r.Context(), uuid)
+			if err != nil {
+				return err
+			}
+			view.Stats = &stats
+		default:
+			return apperror.BadRequestError("include query parameter supports only stats")
+		}
+	}
+
+	auth.Redact(r.Context(), &view)
+	userBytes, err := json.Marshal(view)
 	if err != nil {
 		return err
 	}
@@ -56,101 +113,341 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// func (h *Handler) GetUserByName(w http.ResponseWriter, r *http.Request) error {
-// 	if r.Method != http.MethodGet {
-// 		return apperror.BadRequestError("metod GET")
-// 	}
-// 	h.Logger.Println("GET USER BY NAME")
-// 	w.Header().Set("Content-Type", "application/json")
-
-// 	h.Logger.Println("get name from URL")
-// 	uuid := r.URL.Query().Get("name")
-// 	if uuid == "" {
-// 		return apperror.BadRequestError("uuid query parameter is required and must be a comma separated integers")
-// 	}
+func (h *Handler) GetAllUsers(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("get users")
+	w.Header().Set("Content-Type", "application/json")
 
-// 	user, err := h.UserService.GetByName(r.Context(), uuid)
-// 	if err != nil {
-// 		return err
-// 	}
+	filter, err := parseFilter(r, time.Now())
+	if err != nil {
+		return err
+	}
+	sort, err := parseSort(r)
+	if err != nil {
+		return err
+	}
+	// Filtering and sorting by personal fields would disclose them.
+	if !auth.HasScope(r.Context(), auth.ScopePII) && (filter.Email != "" || !filter.BornFrom.IsZero() ||
+		!filter.BornTo.IsZero() || piiSortFields[sort.Field]) {
+		return apperror.ForbiddenError("email and birth date filters and sorting require the pii scope")
+	}
 
-// 	userBytes, err := json.Marshal(user)
-// 	if err != nil {
-// 		return err
-// 	}
+	p, err := pagination.Parse(r)
+	if err != nil {
+		return err
+	}
 
-// 	w.WriteHeader(http.StatusOK)
-// 	w.Write(userBytes)
-// 	return nil
-// }
+	page, err := h.UserService.GetAll(r.Context(), filter, sort, p)
+	if err != nil {
+		return err
+	}
 
-func (h *Handler) GetAllUsers(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		return apperror.BadRequestError("metod GET")
+	envelope := pagination.NewEnvelope(r, p, page)
+	auth.Redact(r.Context(), &envelope)
+	usersBytes, err := json.Marshal(envelope)
+	if err != nil {
+		return err
 	}
 
-	h.Logger.Println("GET USERS")
+	w.WriteHeader(http.StatusOK)
+	w.Write(usersBytes)
+	return nil
+}
+
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("search users")
 	w.Header().Set("Content-Type", "application/json")
 
-	h.Logger.Println("get limit from URL")
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil || limit < 0 {
-		return apperror.BadRequestError("limit query parameter is required positive integers")
+	p, err := pagination.Parse(r)
+	if err != nil {
+		return err
 	}
 
-	h.Logger.Println("get limit from URL")
-	page, err := strconv.Atoi(r.URL.Query().Get("page"))
-	if err != nil || page < 0 {
-		return apperror.BadRequestError("page query parameter is required positive integers")
+	// Only callers who may see emails can find users by them.
+	query := SearchQuery{
+		Text:         r.URL.Query().Get("q"),
+		IncludeEmail: auth.HasScope(r.Context(), auth.ScopePII),
 	}
-
-	users, err := h.UserService.GetAll(r.Context(), int64(limit), int64(page))
+	page, err := h.UserService.Search(r.Context(), query, p)
 	if err != nil {
 		return err
 	}
 
-	usersBytes, err := json.Marshal(users)
+	envelope := pagination.NewEnvelope(r, p, page)
+	auth.Redact(r.Context(), &envelope)
+	resultsBytes, err := json.Marshal(envelope)
 	if err != nil {
 		return err
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write(usersBytes)
+	w.Write(resultsBytes)
 	return nil
 }
 
+// parseFilter reads the users listing filter. birth_date_from and birth_date_to
+// are inclusive dates, min_age and max_age are full years at now; both ranges
+// narrow down the same birth date range.
+func parseFilter(r *http.Request, now time.Time) (filter Filter, err error) {
+	query := r.URL.Query()
+
+	filter.LastName = query.Get("last_name")
+	filter.Email = query.Get("email")
+	filter.Country = query.Get("country")
+	filter.City = query.Get("city")
+	filter.Gender = query.Get("gender")
+	if filter.Gender != "" && !genders[filter.Gender] {
+		return filter, apperror.BadRequestError("gender query parameter must be one of Male, Female")
+	}
+
+	narrow := func(from, to time.Time) {
+		if !from.IsZero() && from.After(filter.BornFrom) {
+			filter.BornFrom = from
+		}
+		if !to.IsZero() && (filter.BornTo.IsZero() || to.Before(filter.BornTo)) {
+			filter.BornTo = to
+		}
+	}
+	if v := query.Get("birth_date_from"); v != "" {
+		from, err := time.Parse(birthDateLayout, v)
+		if err != nil {
+			return filter, apperror.BadRequestError("birth_date_from query parameter must be a yyyy-mm-dd date")
+		}
+		narrow(from, time.Time{})
+	}
+	if v := query.Get("birth_date_to"); v != "" {
+		to, err := time.Parse(birthDateLayout, v)
+		if err != nil {
+			return filter, apperror.BadRequestError("birth_date_to query parameter must be a yyyy-mm-dd date")
+		}
+		narrow(time.Time{}, to.AddDate(0, 0, 1))
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if v := query.Get("min_age"); v != "" {
+		age, err := strconv.Atoi(v)
+		if err != nil || age < 0 {
+			return filter, apperror.BadRequestError("min_age query parameter must be a non-negative integer")
+		}
+		// at least age years old: born on today minus age years or earlier
+		narrow(time.Time{}, today.AddDate(-age, 0, 1))
+	}
+	if v := query.Get("max_age"); v != "" {
+		age, err := strconv.Atoi(v)
+		if err != nil || age < 0 {
+			return filter, apperror.BadRequestError("max_age query parameter must be a non-negative integer")
+		}
+		// younger than age+1 years: born after today minus age+1 years
+		narrow(today.AddDate(-age-1, 0, 1), time.Time{})
+	}
+
+	if !filter.BornFrom.IsZero() && !filter.BornTo.IsZero() && !filter.BornFrom.Before(filter.BornTo) {
+		return filter, apperror.BadRequestError("birth date and age ranges must not be empty")
+	}
+	return filter, nil
+}
+
+// parseSort reads the sort query parameter, a field name optionally prefixed
+// with - for descending order.
+func parseSort(r *http.Request) (sort Sort, err error) {
+	v := r.URL.Query().Get("sort")
+	if v == "" {
+		return sort, nil
+	}
+	if strings.HasPrefix(v, "-") {
+		sort.Desc = true
+		v = v[1:]
+	}
+	if !SortFields[v] {
+		return sort, apperror.BadRequestError("sort query parameter must be one of last_name, email, country, city, gender, birth_date with optional - prefix")
+	}
+	sort.Field = v
+	return sort, nil
+}
+
 func (h *Handler) GetUsersRaing(w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		return apperror.BadRequestError("metod GET")
+	h.Logger.WithContext(r.Context()).Debug("get users rating")
+	w.Header().Set("Content-Type", "application/json")
+
+	p, err := pagination.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.UserService.GetUsersRating(r.Context(), p)
+	if err != nil {
+		return err
+	}
+
+	envelope := pagination.NewEnvelope(r, p, page)
+	auth.Redact(r.Context(), &envelope)
+	usersRatingsBytes, err := json.Marshal(envelope)
+	if err != nil {
+		return err
 	}
 
-	h.Logger.Println("GET ALL GAMES")
+	w.WriteHeader(http.StatusOK)
+	w.Write(usersRatingsBytes)
+
+	return nil
+}
+
+func (h *Handler) GetLeaderboard(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("get leaderboard")
 	w.Header().Set("Content-Type", "application/json")
 
-	h.Logger.Println("get limit from URL")
-	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
-	if err != nil || limit < 0 {
-		return apperror.BadRequestError("limit query parameter is required positive integers")
+	query := LeaderboardQuery{
+		Metric:  MetricGames,
+		Country: r.URL.Query().Get("country"),
+		City:    r.URL.Query().Get("city"),
+	}
+	if metric := r.URL.Query().Get("metric"); metric != "" {
+		if !leaderboardMetrics[metric] {
+			return apperror.BadRequestError("metric query parameter must be games, points, wins or win_rate")
+		}
+		query.Metric = metric
+	}
+	if window := r.URL.Query().Get("window"); window != "" {
+		if !leaderboardWindows[window] {
+			return apperror.BadRequestError("window query parameter must be daily, weekly or all")
+		}
+		query.Since = WindowStart(window, time.Now())
+	}
+	if gameType := r.URL.Query().Get("game_type"); gameType != "" {
+		value, err := strconv.ParseInt(gameType, 10, 8)
+		if err != nil || value <= 0 {
+			return apperror.BadRequestError("game_type query parameter must be a positive integer")
+		}
+		v := int8(value)
+		query.GameType = &v
 	}
 
-	h.Logger.Println("get limit from URL")
-	page, err := strconv.Atoi(r.URL.Query().Get("page"))
-	if err != nil || page < 0 {
-		return apperror.BadRequestError("page query parameter is required positive integers")
+	p, err := pagination.Parse(r)
+	if err != nil {
+		return err
 	}
 
-	games, err := h.UserService.GetUsersRating(r.Context(), int64(limit), int64(page))
+	page, err := h.UserService.GetLeaderboard(r.Context(), query, p)
 	if err != nil {
 		return err
 	}
 
-	gamesBytes, err := json.Marshal(games)
+	envelope := pagination.NewEnvelope(r, p, page)
+	auth.Redact(r.Context(), &envelope)
+	leaderboardBytes, err := json.Marshal(envelope)
 	if err != nil {
 		return err
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write(gamesBytes)
+	w.Write(leaderboardBytes)
+	return nil
+}
+
+func (h *Handler) GetUserRank(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("get user rank")
+	w.Header().Set("Content-Type", "application/json")
+
+	uuid := router.Param(r, "uuid")
+
+	neighbours := int64(defaultNeighbours)
+	if value := r.URL.Query().Get("neighbours"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 || n > maxNeighbours {
+			return apperror.BadRequestError("neighbours query parameter must be an integer from 0 to 100")
+		}
+		neighbours = n
+	}
+
+	rank, err := h.UserService.GetRank(r.Context(), uuid, neighbours)
+	if err != nil {
+		return err
+	}
+
+	auth.Redact(r.Context(), &rank)
+	rankBytes, err := json.Marshal(rank)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(rankBytes)
+	return nil
+}
+
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("create user")
+	w.Header().Set("Content-Type", "application/json")
+
+	var dto UserDTO
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return apperror.BadRequestError("request body must be a valid user JSON object")
+	}
+
+	uuid, err := h.UserService.Create(r.Context(), dto)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+uuid)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("update user")
+	w.Header().Set("Content-Type", "application/json")
+
+	uuid, dto, err := h.decodeUpdate(r)
+	if err != nil {
+		return err
+	}
+
+	if err = h.UserService.Update(r.Context(), uuid, dto); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) PartiallyUpdateUser(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("partially update user")
+	w.Header().Set("Content-Type", "application/json")
+
+	uuid, dto, err := h.decodeUpdate(r)
+	if err != nil {
+		return err
+	}
+
+	if err = h.UserService.PartiallyUpdate(r.Context(), uuid, dto); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) decodeUpdate(r *http.Request) (uuid string, dto UserDTO, err error) {
+	uuid = router.Param(r, "uuid")
+
+	defer r.Body.Close()
+	if err = json.NewDecoder(r.Body).Decode(&dto); err != nil {
+		return uuid, dto, apperror.BadRequestError("request body must be a valid user JSON object")
+	}
+	return uuid, dto, nil
+}
+
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
+	h.Logger.WithContext(r.Context()).Debug("delete user")
+	w.Header().Set("Content-Type", "application/json")
+
+	uuid := router.Param(r, "uuid")
+
+	if err := h.UserService.Delete(r.Context(), uuid); err != nil {
+		return err
+	}
 
+	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
@@ -0,0 +1,116 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/metrics"
+)
+
+// instrumentedStorage observes the duration of the calls to a Storage.
+type instrumentedStorage struct {
+	storage Storage
+	metrics *metrics.StorageMetrics
+}
+
+// NewInstrumentedStorage wraps storage, so its calls are observed by m as the user storage.
+func NewInstrumentedStorage(storage Storage, m *metrics.StorageMetrics) Storage {
+	return &instrumentedStorage{storage: storage, metrics: m}
+}
+
+func (s *instrumentedStorage) FindById(ctx context.Context, uuid string) (result User, err error) {
+	defer s.metrics.Observe("user", "FindById", time.Now(), &err)
+	return s.storage.FindById(ctx, uuid)
+}
+
+func (s *instrumentedStorage) FindAll(ctx context.Context, filter Filter, sort Sort, p pagination.Params) (result []User, next string, err error) {
+	defer s.metrics.Observe("user", "FindAll", time.Now(), &err)
+	return s.storage.FindAll(ctx, filter, sort, p)
+}
+
+func (s *instrumentedStorage) AggregateRatingUsers(ctx context.Context, p pagination.Params) (result []UserRating, next string, err error) {
+	defer s.metrics.Observe("user", "AggregateRatingUsers", time.Now(), &err)
+	return s.storage.AggregateRatingUsers(ctx, p)
+}
+
+func (s *instrumentedStorage) Search(ctx context.Context, query SearchQuery, p pagination.Params) (result []SearchResult, total int64, err error) {
+	defer s.metrics.Observe("user", "Search", time.Now(), &err)
+	return s.storage.Search(ctx, query, p)
+}
+
+func (s *instrumentedStorage) AggregateUserStats(ctx context.Context, uuid string) (result UserStats, err error) {
+	defer s.metrics.Observe("user", "AggregateUserStats", time.Now(), &err)
+	return s.storage.AggregateUserStats(ctx, uuid)
+}
+
+func (s *instrumentedStorage) AggregateLeaderboard(ctx context.Context, query LeaderboardQuery, p pagination.Params) (result []LeaderboardEntry, total int64, err error) {
+	defer s.metrics.Observe("user", "AggregateLeaderboard", time.Now(), &err)
+	return s.storage.AggregateLeaderboard(ctx, query, p)
+}
+
+func (s *instrumentedStorage) FindRank(ctx context.Context, uuid string, neighbours int64) (result UserRank, err error) {
+	defer s.metrics.Observe("user", "FindRank", time.Now(), &err)
+	return s.storage.FindRank(ctx, uuid, neighbours)
+}
+
+func (s *instrumentedStorage) Count(ctx context.Context, filter Filter) (result int64, err error) {
+	defer s.metrics.Observe("user", "Count", time.Now(), &err)
+	return s.storage.Count(ctx, filter)
+}
+
+func (s *instrumentedStorage) Create(ctx context.Context, user User) (result string, err error) {
+	defer s.metrics.Observe("user", "Create", time.Now(), &err)
+	return s.storage.Create(ctx, user)
+}
+
+func (s *instrumentedStorage) Update(ctx context.Context, user User) (err error) {
+	defer s.metrics.Observe("user", "Update", time.Now(), &err)
+	err = s.storage.Update(ctx, user)
+	return err
+}
+
+func (s *instrumentedStorage) Delete(ctx context.Context, uuid string) (err error) {
+	defer s.metrics.Observe("user", "Delete", time.Now(), &err)
+	err = s.storage.Delete(ctx, uuid)
+	return err
+}
+
+func (s *instrumentedStorage) CreateAccount(ctx context.Context, account Account) (result string, err error) {
+	defer s.metrics.Observe("user", "CreateAccount", time.Now(), &err)
+	return s.storage.CreateAccount(ctx, account)
+}
+
+func (s *instrumentedStorage) FindAccount(ctx context.Context, email string) (result Account, err error) {
+	defer s.metrics.Observe("user", "FindAccount", time.Now(), &err)
+	return s.storage.FindAccount(ctx, email)
+}
+
+func (s *instrumentedStorage) FindAccountById(ctx context.Context, uuid string) (result Account, err error) {
+	defer s.metrics.Observe("user", "FindAccountById", time.Now(), &err)
+	return s.storage.FindAccountById(ctx, uuid)
+}
+
+func (s *instrumentedStorage) SetPassword(ctx context.Context, uuid, hash string) (err error) {
+	defer s.metrics.Observe("user", "SetPassword", time.Now(), &err)
+	err = s.storage.SetPassword(ctx, uuid, hash)
+	return err
+}
+
+func (s *instrumentedStorage) AddSession(ctx context.Context, uuid string, session Session) (err error) {
+	defer s.metrics.Observe("user", "AddSession", time.Now(), &err)
+	err = s.storage.AddSession(ctx, uuid, session)
+	return err
+}
+
+func (s *instrumentedStorage) ReplaceSession(ctx context.Context, uuid, id string, session Session) (err error) {
+	defer s.metrics.Observe("user", "ReplaceSession", time.Now(), &err)
+	err = s.storage.ReplaceSession(ctx, uuid, id, session)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteSession(ctx context.Context, uuid, id string) (err error) {
+	defer s.metrics.Observe("user", "DeleteSession", time.Now(), &err)
+	err = s.storage.DeleteSession(ctx, uuid, id)
+	return err
+}
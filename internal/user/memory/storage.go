@@ -0,0 +1,637 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/game"
+	"github.com/IvanKyrylov/user-game-api/internal/memdb"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/internal/user"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type storage struct {
+	db     *memdb.DB
+	logger *logging.Logger
+}
+
+func NewStorage(db *memdb.DB, logger *logging.Logger) user.Storage {
+	return &storage{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// documents returns all users ordered by id. The caller must hold the lock.
+func (s *storage) documents() []*memdb.UserDocument {
+	documents := make([]*memdb.UserDocument, 0, len(s.db.Users))
+	for _, document := range s.db.Users {
+		documents = append(documents, document)
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return memdb.Less(documents[i].UUID, documents[j].UUID)
+	})
+	return documents
+}
+
+func (s *storage) FindById(ctx context.Context, uuid string) (user user.User, err error) {
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return user, apperror.ErrInvalidID
+	}
+
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	document, ok := s.db.Users[userId]
+	if !ok {
+		return user, apperror.ErrNotFound
+	}
+	return document.User, nil
+}
+
+// searchScore weighs the query tokens found in the user fields the same way
+// the Mongo text index weighs the fields, the email only with includeEmail.
+// Zero means no match.
+func searchScore(tokens []string, u user.User, includeEmail bool) (score float64) {
+	fields := []struct {
+		value  string
+		weight float64
+	}{
+		{u.LastName, 10},
+		{u.City, 2},
+		{u.Country, 1},
+	}
+	if includeEmail {
+		fields = append(fields, struct {
+			value  string
+			weight float64
+		}{u.Email, 5})
+	}
+	for _, field := range fields {
+		words := user.SearchTokens(field.value)
+		for _, token := range tokens {
+			for _, word := range words {
+				if word == token {
+					score += field.weight / float64(len(words))
+				}
+			}
+		}
+	}
+	return score
+}
+
+func (s *storage) Search(ctx context.Context, query user.SearchQuery, p pagination.Params) (results []user.SearchResult, total int64, err error) {
+	tokens := user.SearchTokens(query.Text)
+
+	s.db.RLock()
+	var matched []user.SearchResult
+	for _, document := range s.documents() {
+		if score := searchScore(tokens, document.User, query.IncludeEmail); score > 0 {
+			matched = append(matched, user.SearchResult{User: document.User, Score: score})
+		}
+	}
+	s.db.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Score > matched[j].Score
+	})
+
+	start, end := memdb.Page(len(matched), p.Limit, p.Page)
+	return matched[start:end], int64(len(matched)), nil
+}
+
+type idCursor struct {
+	ID primitive.ObjectID `json:"id"`
+}
+
+type ratingCursor struct {
+	Rating int64              `json:"rating"`
+	ID     primitive.ObjectID `json:"id"`
+}
+
+// match reports whether u passes filter.
+func match(filter user.Filter, u user.User) bool {
+	if filter.LastName != "" && !strings.HasPrefix(strings.ToLower(u.LastName), strings.ToLower(filter.LastName)) {
+		return false
+	}
+	if filter.Email != "" && u.Email != filter.Email {
+		return false
+	}
+	if filter.Country != "" && u.Country != filter.Country {
+		return false
+	}
+	if filter.City != "" && u.City != filter.City {
+		return false
+	}
+	if filter.Gender != "" && u.Gender != filter.Gender {
+		return false
+	}
+	if !filter.BornFrom.IsZero() && u.BirthDate < primitive.NewDateTimeFromTime(filter.BornFrom) {
+		return false
+	}
+	if !filter.BornTo.IsZero() && u.BirthDate >= primitive.NewDateTimeFromTime(filter.BornTo) {
+		return false
+	}
+	return true
+}
+
+// compare orders a and b by field the way Mongo sorts the stored values.
+func compare(field string, a, b user.User) int {
+	switch field {
+	case "last_name":
+		return strings.Compare(a.LastName, b.LastName)
+	case "email":
+		return strings.Compare(a.Email, b.Email)
+	case "country":
+		return strings.Compare(a.Country, b.Country)
+	case "city":
+		return strings.Compare(a.City, b.City)
+	case "gender":
+		return strings.Compare(a.Gender, b.Gender)
+	case "birth_date":
+		switch {
+		case a.BirthDate < b.BirthDate:
+			return -1
+		case a.BirthDate > b.BirthDate:
+			return 1
+		}
+	}
+	return 0
+}
+
+func (s *storage) FindAll(ctx context.Context, filter user.Filter, order user.Sort, p pagination.Params) (users []user.User, nextCursor string, err error) {
+	var cursor idCursor
+	if p.Cursor != "" {
+		if err = pagination.DecodeCursor(p.Cursor, &cursor); err != nil {
+			return users, nextCursor, err
+		}
+	}
+
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	var documents []*memdb.UserDocument
+	for _, document := range s.documents() {
+		if match(filter, document.User) {
+			documents = append(documents, document)
+		}
+	}
+	if order.Field != "" {
+		sort.SliceStable(documents, func(i, j int) bool {
+			c := compare(order.Field, documents[i].User, documents[j].User)
+			if order.Desc {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	if !p.Keyset {
+		start, end := memdb.Page(len(documents), p.Limit, p.Page)
+		for _, document := range documents[start:end] {
+			users = append(users, document.User)
+		}
+		return users, nextCursor, nil
+	}
+
+	start := sort.Search(len(documents), func(i int) bool {
+		return memdb.Less(cursor.ID, documents[i].UUID)
+	})
+	documents = documents[start:]
+	if int64(len(documents)) > p.Limit {
+		documents = documents[:p.Limit]
+		nextCursor, err = pagination.EncodeCursor(idCursor{ID: documents[len(documents)-1].UUID})
+		if err != nil {
+			return users, nextCursor, err
+		}
+	}
+	for _, document := range documents {
+		users = append(users, document.User)
+	}
+	return users, nextCursor, nil
+}
+
+func (s *storage) AggregateRatingUsers(ctx context.Context, p pagination.Params) (usersRatings []user.UserRating, nextCursor string, err error) {
+	var cursor *ratingCursor
+	if p.Cursor != "" {
+		cursor = &ratingCursor{}
+		if err = pagination.DecodeCursor(p.Cursor, cursor); err != nil {
+			return usersRatings, nextCursor, err
+		}
+	}
+
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	documents := s.documents()
+	sort.SliceStable(documents, func(i, j int) bool {
+		return documents[i].Rating > documents[j].Rating
+	})
+
+	if !p.Keyset {
+		start, end := memdb.Page(len(documents), p.Limit, p.Page)
+		documents = documents[start:end]
+	} else {
+		if cursor != nil {
+			start := sort.Search(len(documents), func(i int) bool {
+				d := documents[i]
+				return d.Rating < cursor.Rating || d.Rating == cursor.Rating && memdb.Less(cursor.ID, d.UUID)
+			})
+			documents = documents[start:]
+		}
+		if int64(len(documents)) > p.Limit {
+			documents = documents[:p.Limit]
+			last := documents[len(documents)-1]
+			nextCursor, err = pagination.EncodeCursor(ratingCursor{Rating: last.Rating, ID: last.UUID})
+			if err != nil {
+				return usersRatings, nextCursor, err
+			}
+		}
+	}
+
+	for _, document := range documents {
+		usersRatings = append(usersRatings, user.UserRating{
+			User:   document.User,
+			Rating: document.Rating,
+		})
+	}
+	return usersRatings, nextCursor, nil
+}
+
+func (s *storage) AggregateUserStats(ctx context.Context, uuid string) (stats user.UserStats, err error) {
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return stats, apperror.ErrInvalidID
+	}
+
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	gameTypes := make(map[int8]int64)
+	for _, g := range s.db.Games {
+		if g.UserID != userId {
+			continue
+		}
+		stats.TotalGames++
+		if g.WinStatus == 1 {
+			stats.Wins++
+		}
+		stats.TotalPointsGained += int64(g.PointsGained)
+		gameTypes[g.GameType]++
+		if stats.LastPlayed == nil || g.Created.After(*stats.LastPlayed) {
+			created := g.Created
+			stats.LastPlayed = &created
+		}
+	}
+	if stats.TotalGames == 0 {
+		return stats, nil
+	}
+
+	stats.Losses = stats.TotalGames - stats.Wins
+	stats.WinRate = float64(stats.Wins) / float64(stats.TotalGames)
+	stats.AvgPointsGained = float64(stats.TotalPointsGained) / float64(stats.TotalGames)
+	for gameType, played := range gameTypes {
+		favourite := gameTypes[stats.FavouriteGameType]
+		if played > favourite || played == favourite && gameType < stats.FavouriteGameType {
+			stats.FavouriteGameType = gameType
+		}
+	}
+	return stats, nil
+}
+
+// leaderboardValue returns the value entries are ranked by for metric.
+func leaderboardValue(metric string, entry user.LeaderboardEntry) float64 {
+	switch metric {
+	case user.MetricPoints:
+		return float64(entry.Points)
+	case user.MetricWins:
+		return float64(entry.Wins)
+	case user.MetricWinRate:
+		return entry.WinRate
+	}
+	return float64(entry.Games)
+}
+
+func (s *storage) AggregateLeaderboard(ctx context.Context, query user.LeaderboardQuery, p pagination.Params) (entries []user.LeaderboardEntry, total int64, err error) {
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	byUser := make(map[primitive.ObjectID]*user.LeaderboardEntry)
+	for _, g := range s.db.Games {
+		if query.GameType != nil && g.GameType != *query.GameType {
+			continue
+		}
+		if !query.Since.IsZero() && g.Created.Before(query.Since) {
+			continue
+		}
+		document, ok := s.db.Users[g.UserID]
+		if !ok {
+			continue
+		}
+		if query.Country != "" && document.Country != query.Country || query.City != "" && document.City != query.City {
+			continue
+		}
+
+		entry, ok := byUser[g.UserID]
+		if !ok {
+			entry = &user.LeaderboardEntry{User: document.User}
+			byUser[g.UserID] = entry
+		}
+		entry.Games++
+		if g.WinStatus == 1 {
+			entry.Wins++
+		}
+		entry.Points += int64(g.PointsGained)
+	}
+
+	ranked := make([]user.LeaderboardEntry, 0, len(byUser))
+	for _, entry := range byUser {
+		entry.WinRate = float64(entry.Wins) / float64(entry.Games)
+		ranked = append(ranked, *entry)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if va, vb := leaderboardValue(query.Metric, a), leaderboardValue(query.Metric, b); va != vb {
+			return va > vb
+		}
+		if query.Metric == user.MetricWinRate && a.Games != b.Games {
+			return a.Games > b.Games
+		}
+		return memdb.Less(a.User.UUID, b.User.UUID)
+	})
+
+	start, end := memdb.Page(len(ranked), p.Limit, p.Page)
+	for i := start; i < end; i++ {
+		ranked[i].Rank = int64(i) + 1
+		entries = append(entries, ranked[i])
+	}
+	return entries, int64(len(ranked)), nil
+}
+
+func (s *storage) FindRank(ctx context.Context, uuid string, neighbours int64) (rank user.UserRank, err error) {
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return rank, apperror.ErrInvalidID
+	}
+
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	if _, ok := s.db.Users[userId]; !ok {
+		return rank, apperror.ErrNotFound
+	}
+
+	documents := s.documents()
+	sort.SliceStable(documents, func(i, j int) bool {
+		return documents[i].Rating > documents[j].Rating
+	})
+
+	var position int
+	for i, document := range documents {
+		if document.UUID == userId {
+			position = i
+			break
+		}
+	}
+	rank = user.UserRank{
+		Rank:   int64(position) + 1,
+		User:   documents[position].User,
+		Rating: documents[position].Rating,
+	}
+
+	start, end := position-int(neighbours), position+int(neighbours)+1
+	if start < 0 {
+		start = 0
+	}
+	if end > len(documents) {
+		end = len(documents)
+	}
+	for i := start; i < end; i++ {
+		rating := user.UserRating{
+			Rank:   int64(i) + 1,
+			User:   documents[i].User,
+			Rating: documents[i].Rating,
+		}
+		if i < position {
+			rank.Above = append(rank.Above, rating)
+		} else if i > position {
+			rank.Below = append(rank.Below, rating)
+		}
+	}
+	return rank, nil
+}
+
+func (s *storage) Count(ctx context.Context, filter user.Filter) (count int64, err error) {
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	for _, document := range s.db.Users {
+		if match(filter, document.User) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *storage) Create(ctx context.Context, user user.User) (uuid string, err error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	user.UUID = primitive.NewObjectID()
+	s.db.Users[user.UUID] = &memdb.UserDocument{User: user}
+	return user.UUID.Hex(), nil
+}
+
+func (s *storage) Update(ctx context.Context, user user.User) error {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	document, ok := s.db.Users[user.UUID]
+	if !ok {
+		return apperror.ErrNotFound
+	}
+
+	// Mirror the Mongo $set of a document with omitempty fields: only the
+	// non-zero fields overwrite the stored ones.
+	if user.Email != "" {
+		document.Email = user.Email
+	}
+	if user.LastName != "" {
+		document.LastName = user.LastName
+	}
+	if user.Country != "" {
+		document.Country = user.Country
+	}
+	if user.City != "" {
+		document.City = user.City
+	}
+	if user.Gender != "" {
+		document.Gender = user.Gender
+	}
+	if user.BirthDate != 0 {
+		document.BirthDate = user.BirthDate
+	}
+	return nil
+}
+
+func (s *storage) Delete(ctx context.Context, uuid string) error {
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidID
+	}
+
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	if _, ok := s.db.Users[userId]; !ok {
+		return apperror.ErrNotFound
+	}
+	delete(s.db.Users, userId)
+
+	games := make([]game.Game, 0, len(s.db.Games))
+	for _, g := range s.db.Games {
+		if g.UserID != userId {
+			games = append(games, g)
+		}
+	}
+	s.db.Games = games
+	s.db.GameIndex = make(map[primitive.ObjectID]int, len(games))
+	for i, g := range games {
+		s.db.GameIndex[g.ID] = i
+	}
+	return nil
+}
+
+func (s *storage) CreateAccount(ctx context.Context, account user.Account) (uuid string, err error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	// Mirror the unique users_account_email index.
+	for _, document := range s.db.Users {
+		if document.PasswordHash != "" && document.Email == account.Email {
+			return uuid, apperror.ErrConflict
+		}
+	}
+
+	account.UUID = primitive.NewObjectID()
+	s.db.Users[account.UUID] = &memdb.UserDocument{
+		User:         account.User,
+		PasswordHash: account.PasswordHash,
+		Sessions:     account.Sessions,
+	}
+	return account.UUID.Hex(), nil
+}
+
+func (s *storage) FindAccount(ctx context.Context, email string) (account user.Account, err error) {
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	for _, document := range s.db.Users {
+		if document.PasswordHash != "" && document.Email == email {
+			return toAccount(document), nil
+		}
+	}
+	return account, apperror.ErrNotFound
+}
+
+func (s *storage) FindAccountById(ctx context.Context, uuid string) (account user.Account, err error) {
+	s.db.RLock()
+	defer s.db.RUnlock()
+
+	document, err := s.account(uuid)
+	if err != nil {
+		return account, err
+	}
+	return toAccount(document), nil
+}
+
+func (s *storage) SetPassword(ctx context.Context, uuid, hash string) error {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	document, err := s.account(uuid)
+	if err != nil {
+		return err
+	}
+	document.PasswordHash = hash
+	document.Sessions = nil
+	return nil
+}
+
+func (s *storage) AddSession(ctx context.Context, uuid string, session user.Session) error {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	document, err := s.account(uuid)
+	if err != nil {
+		return err
+	}
+	sessions := append(document.Sessions, session)
+	if len(sessions) > user.MaxSessions {
+		sessions = sessions[len(sessions)-user.MaxSessions:]
+	}
+	document.Sessions = append([]user.Session(nil), sessions...)
+	return nil
+}
+
+func (s *storage) ReplaceSession(ctx context.Context, uuid, id string, session user.Session) error {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	document, err := s.account(uuid)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i, stored := range document.Sessions {
+		if stored.ID == id && stored.ExpiresAt.After(now) {
+			document.Sessions[i] = session
+			return nil
+		}
+	}
+	return apperror.ErrNotFound
+}
+
+func (s *storage) DeleteSession(ctx context.Context, uuid, id string) error {
+	s.db.Lock()
+	defer s.db.Unlock()
+
+	document, err := s.account(uuid)
+	if err != nil {
+		return err
+	}
+	for i, stored := range document.Sessions {
+		if stored.ID == id {
+			document.Sessions = append(document.Sessions[:i:i], document.Sessions[i+1:]...)
+			return nil
+		}
+	}
+	return apperror.ErrNotFound
+}
+
+// account returns the document of the account uuid, the caller holds the lock.
+func (s *storage) account(uuid string) (*memdb.UserDocument, error) {
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return nil, apperror.ErrInvalidID
+	}
+	document, ok := s.db.Users[userId]
+	if !ok || document.PasswordHash == "" {
+		return nil, apperror.ErrNotFound
+	}
+	return document, nil
+}
+
+func toAccount(document *memdb.UserDocument) user.Account {
+	return user.Account{
+		User:         document.User,
+		PasswordHash: document.PasswordHash,
+		Sessions:     append([]user.Session(nil), document.Sessions...),
+	}
+}
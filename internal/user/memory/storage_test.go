@@ -0,0 +1,338 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/game"
+	"github.com/IvanKyrylov/user-game-api/internal/memdb"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/internal/user"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var day = time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func newStorage() (*memdb.DB, user.Storage) {
+	db := memdb.New()
+	return db, NewStorage(db, logging.Default())
+}
+
+// addUser stores u with rating and returns its id.
+func addUser(db *memdb.DB, u user.User, rating int64) primitive.ObjectID {
+	u.UUID = primitive.NewObjectID()
+	db.Users[u.UUID] = &memdb.UserDocument{User: u, Rating: rating}
+	return u.UUID
+}
+
+func addGame(db *memdb.DB, g game.Game) {
+	g.ID = primitive.NewObjectID()
+	db.GameIndex[g.ID] = len(db.Games)
+	db.Games = append(db.Games, g)
+}
+
+func TestFindAllKeyset(t *testing.T) {
+	db, storage := newStorage()
+	var want []primitive.ObjectID
+	for i := 0; i < 5; i++ {
+		want = append(want, addUser(db, user.User{Country: "UA"}, 0))
+	}
+	addUser(db, user.User{Country: "PL"}, 0)
+
+	var got []primitive.ObjectID
+	p := pagination.Params{Limit: 2, Keyset: true}
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("keyset pagination does not end")
+		}
+		users, next, err := storage.FindAll(context.Background(), user.Filter{Country: "UA"}, user.Sort{}, p)
+		if err != nil {
+			t.Fatalf("FindAll: %v", err)
+		}
+		for _, u := range users {
+			got = append(got, u.UUID)
+		}
+		if next == "" {
+			break
+		}
+		p.Cursor = next
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d users, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("user %d = %s, want %s", i, got[i].Hex(), want[i].Hex())
+		}
+	}
+}
+
+func TestFindAllSortAndOffset(t *testing.T) {
+	db, storage := newStorage()
+	for _, lastName := range []string{"Shevchenko", "Franko", "Ukrainka", "Kostenko"} {
+		addUser(db, user.User{LastName: lastName}, 0)
+	}
+
+	users, _, err := storage.FindAll(context.Background(), user.Filter{}, user.Sort{Field: "last_name", Desc: true}, pagination.Params{Limit: 2, Page: 1})
+	if err != nil {
+		t.Fatalf("FindAll: %v", err)
+	}
+	if len(users) != 2 || users[0].LastName != "Kostenko" || users[1].LastName != "Franko" {
+		t.Errorf("second page = %v, want Kostenko and Franko", users)
+	}
+
+	count, err := storage.Count(context.Background(), user.Filter{LastName: "k"})
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("users with last name prefix k = %d, want 1", count)
+	}
+}
+
+func TestAggregateRatingUsersKeyset(t *testing.T) {
+	db, storage := newStorage()
+	// ties on the rating are ordered by id
+	top := addUser(db, user.User{}, 3)
+	tieFirst := addUser(db, user.User{}, 2)
+	tieSecond := addUser(db, user.User{}, 2)
+	last := addUser(db, user.User{}, 1)
+	want := []primitive.ObjectID{top, tieFirst, tieSecond, last}
+
+	var got []primitive.ObjectID
+	p := pagination.Params{Limit: 1, Keyset: true}
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("keyset pagination does not end")
+		}
+		ratings, next, err := storage.AggregateRatingUsers(context.Background(), p)
+		if err != nil {
+			t.Fatalf("AggregateRatingUsers: %v", err)
+		}
+		for _, rating := range ratings {
+			got = append(got, rating.User.UUID)
+		}
+		if next == "" {
+			break
+		}
+		p.Cursor = next
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d users, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("user %d = %s, want %s", i, got[i].Hex(), want[i].Hex())
+		}
+	}
+}
+
+func TestAggregateLeaderboard(t *testing.T) {
+	db, storage := newStorage()
+	alice := addUser(db, user.User{LastName: "Alice", Country: "UA"}, 0)
+	bob := addUser(db, user.User{LastName: "Bob", Country: "UA"}, 0)
+	carol := addUser(db, user.User{LastName: "Carol", Country: "PL"}, 0)
+
+	addGame(db, game.Game{UserID: alice, Created: day, WinStatus: 1, PointsGained: 10, GameType: 1})
+	addGame(db, game.Game{UserID: alice, Created: day, PointsGained: 5, GameType: 2})
+	addGame(db, game.Game{UserID: bob, Created: day, WinStatus: 1, PointsGained: 1, GameType: 1})
+	addGame(db, game.Game{UserID: bob, Created: day.AddDate(0, 0, 1), WinStatus: 1, PointsGained: 1, GameType: 1})
+	addGame(db, game.Game{UserID: carol, Created: day, WinStatus: 1, PointsGained: 50, GameType: 1})
+	// games of a deleted user are not ranked
+	addGame(db, game.Game{UserID: primitive.NewObjectID(), Created: day, WinStatus: 1, PointsGained: 100, GameType: 1})
+
+	tests := []struct {
+		name      string
+		query     user.LeaderboardQuery
+		p         pagination.Params
+		want      []primitive.ObjectID
+		wantRanks []int64
+		wantTotal int64
+	}{
+		{
+			name:      "wins",
+			query:     user.LeaderboardQuery{Metric: user.MetricWins},
+			p:         pagination.Params{Limit: 10},
+			want:      []primitive.ObjectID{bob, alice, carol},
+			wantRanks: []int64{1, 2, 3},
+			wantTotal: 3,
+		},
+		{
+			name:      "points second page",
+			query:     user.LeaderboardQuery{Metric: user.MetricPoints},
+			p:         pagination.Params{Limit: 2, Page: 1},
+			want:      []primitive.ObjectID{bob},
+			wantRanks: []int64{3},
+			wantTotal: 3,
+		},
+		{
+			name:      "win rate breaks ties by games",
+			query:     user.LeaderboardQuery{Metric: user.MetricWinRate},
+			p:         pagination.Params{Limit: 10},
+			want:      []primitive.ObjectID{bob, carol, alice},
+			wantRanks: []int64{1, 2, 3},
+			wantTotal: 3,
+		},
+		{
+			name:      "country",
+			query:     user.LeaderboardQuery{Metric: user.MetricPoints, Country: "UA"},
+			p:         pagination.Params{Limit: 10},
+			want:      []primitive.ObjectID{alice, bob},
+			wantRanks: []int64{1, 2},
+			wantTotal: 2,
+		},
+		{
+			name:      "since",
+			query:     user.LeaderboardQuery{Metric: user.MetricGames, Since: day.AddDate(0, 0, 1)},
+			p:         pagination.Params{Limit: 10},
+			want:      []primitive.ObjectID{bob},
+			wantRanks: []int64{1},
+			wantTotal: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, total, err := storage.AggregateLeaderboard(context.Background(), tt.query, tt.p)
+			if err != nil {
+				t.Fatalf("AggregateLeaderboard: %v", err)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", total, tt.wantTotal)
+			}
+			if len(entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(tt.want))
+			}
+			for i, entry := range entries {
+				if entry.User.UUID != tt.want[i] || entry.Rank != tt.wantRanks[i] {
+					t.Errorf("entry %d = %s rank %d, want %s rank %d", i, entry.User.LastName, entry.Rank, tt.want[i].Hex(), tt.wantRanks[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAggregateUserStats(t *testing.T) {
+	db, storage := newStorage()
+	player := addUser(db, user.User{}, 0)
+	addGame(db, game.Game{UserID: player, Created: day, WinStatus: 1, PointsGained: 10, GameType: 2})
+	addGame(db, game.Game{UserID: player, Created: day.Add(time.Hour), PointsGained: 20, GameType: 2})
+	addGame(db, game.Game{UserID: player, Created: day.Add(-time.Hour), PointsGained: 0, GameType: 1})
+
+	stats, err := storage.AggregateUserStats(context.Background(), player.Hex())
+	if err != nil {
+		t.Fatalf("AggregateUserStats: %v", err)
+	}
+	if stats.TotalGames != 3 || stats.Wins != 1 || stats.Losses != 2 || stats.TotalPointsGained != 30 ||
+		stats.AvgPointsGained != 10 || stats.FavouriteGameType != 2 || !stats.LastPlayed.Equal(day.Add(time.Hour)) {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if _, err := storage.AggregateUserStats(context.Background(), "abc"); !errors.Is(err, apperror.ErrInvalidID) {
+		t.Errorf("err = %v, want ErrInvalidID", err)
+	}
+}
+
+func TestFindRank(t *testing.T) {
+	db, storage := newStorage()
+	var ids []primitive.ObjectID
+	for rating := int64(5); rating > 0; rating-- {
+		ids = append(ids, addUser(db, user.User{}, rating))
+	}
+
+	rank, err := storage.FindRank(context.Background(), ids[2].Hex(), 1)
+	if err != nil {
+		t.Fatalf("FindRank: %v", err)
+	}
+	if rank.Rank != 3 || rank.Rating != 3 {
+		t.Errorf("rank = %d rating %d, want rank 3 rating 3", rank.Rank, rank.Rating)
+	}
+	if len(rank.Above) != 1 || rank.Above[0].User.UUID != ids[1] || rank.Above[0].Rank != 2 {
+		t.Errorf("above = %v, want the user ranked 2", rank.Above)
+	}
+	if len(rank.Below) != 1 || rank.Below[0].User.UUID != ids[3] || rank.Below[0].Rank != 4 {
+		t.Errorf("below = %v, want the user ranked 4", rank.Below)
+	}
+
+	if _, err := storage.FindRank(context.Background(), primitive.NewObjectID().Hex(), 1); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db, storage := newStorage()
+	shevchenko := addUser(db, user.User{LastName: "Shevchenko", Email: "taras@kyiv.ua", City: "Lviv"}, 0)
+	kyiv := addUser(db, user.User{LastName: "Kyiv", City: "Kyiv"}, 0)
+	addUser(db, user.User{LastName: "Franko", Email: "ivan@kyiv.ua"}, 0)
+
+	page := pagination.Params{Limit: 10}
+	tests := []struct {
+		name      string
+		query     user.SearchQuery
+		p         pagination.Params
+		want      []primitive.ObjectID
+		wantTotal int64
+	}{
+		{"last name over city", user.SearchQuery{Text: "kyiv lviv"}, page, []primitive.ObjectID{kyiv, shevchenko}, 2},
+		{"limit 0", user.SearchQuery{Text: "kyiv lviv"}, pagination.Params{}, []primitive.ObjectID{kyiv, shevchenko}, 2},
+		{"second page", user.SearchQuery{Text: "kyiv lviv"}, pagination.Params{Limit: 1, Page: 1}, []primitive.ObjectID{shevchenko}, 2},
+		{"email not matched", user.SearchQuery{Text: "taras"}, page, nil, 0},
+		{"email", user.SearchQuery{Text: "taras", IncludeEmail: true}, page, []primitive.ObjectID{shevchenko}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, total, err := storage.Search(context.Background(), tt.query, tt.p)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if total != tt.wantTotal || len(results) != len(tt.want) {
+				t.Fatalf("got %d results of %d, want %d of %d", len(results), total, len(tt.want), tt.wantTotal)
+			}
+			for i, result := range results {
+				if result.User.UUID != tt.want[i] {
+					t.Errorf("result %d = %s, want %s", i, result.User.LastName, tt.want[i].Hex())
+				}
+			}
+		})
+	}
+
+	// the same query finds the same users with and without the pii scope
+	// when no email matches
+	for _, includeEmail := range []bool{false, true} {
+		results, total, err := storage.Search(context.Background(), user.SearchQuery{Text: "lviv", IncludeEmail: includeEmail}, page)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if total != 1 || len(results) != 1 || results[0].User.UUID != shevchenko {
+			t.Errorf("lviv with email %v = %d results of %d, want shevchenko", includeEmail, len(results), total)
+		}
+	}
+}
+
+func TestDeleteRemovesGames(t *testing.T) {
+	db, storage := newStorage()
+	deleted := addUser(db, user.User{}, 2)
+	kept := addUser(db, user.User{}, 1)
+	addGame(db, game.Game{UserID: deleted, Created: day})
+	addGame(db, game.Game{UserID: kept, Created: day})
+	addGame(db, game.Game{UserID: deleted, Created: day})
+
+	if err := storage.Delete(context.Background(), deleted.Hex()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if len(db.Games) != 1 || db.Games[0].UserID != kept {
+		t.Fatalf("games = %v, want the game of the kept user", db.Games)
+	}
+	if i, ok := db.GameIndex[db.Games[0].ID]; !ok || i != 0 || len(db.GameIndex) != 1 {
+		t.Errorf("game index = %v, want the kept game at 0", db.GameIndex)
+	}
+
+	if err := storage.Delete(context.Background(), deleted.Hex()); !errors.Is(err, apperror.ErrNotFound) {
+		t.Errorf("second Delete: err = %v, want ErrNotFound", err)
+	}
+}
@@ -1,20 +1,171 @@
 package user
 
 import (
+	"strings"
+	"time"
+	"unicode"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
 	UUID      primitive.ObjectID `json:"UUID,omitempty" bson:"_id,omitempty"`
-	Email     string             `json:"email,omitempty" bson:"email,omitempty"`
+	Email     string             `json:"email,omitempty" bson:"email,omitempty" pii:"true"`
 	LastName  string             `json:"last_name,omitempty" bson:"last_name,omitempty"`
 	Country   string             `json:"country,omitempty" bson:"country,omitempty"`
 	City      string             `json:"city,omitempty" bson:"city,omitempty"`
 	Gender    string             `json:"gender,omitempty" bson:"gender,omitempty"`
-	BirthDate primitive.DateTime `json:"birth_date,omitempty" bson:"birth_date,omitempty"`
+	BirthDate primitive.DateTime `json:"birth_date,omitempty" bson:"birth_date,omitempty" pii:"true"`
+}
+
+// Account is a user who signed up with a password. PasswordHash is the bcrypt
+// hash of the password, Sessions are the refresh tokens issued to the player.
+type Account struct {
+	User         `bson:",inline"`
+	PasswordHash string    `json:"-" bson:"password_hash"`
+	Sessions     []Session `json:"-" bson:"sessions,omitempty"`
+}
+
+// Session is an issued refresh token. ID is the SHA-256 of the token secret,
+// so the stored sessions can not be used as refresh tokens.
+type Session struct {
+	ID        string    `bson:"id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// MaxSessions is the number of refresh tokens an account keeps, logging in
+// once more ends the oldest session.
+const MaxSessions = 10
+
+// UserView is a user with the optional expansions requested via include.
+type UserView struct {
+	User
+	Stats *UserStats `json:"stats,omitempty"`
+}
+
+// UserStats summarizes the games played by a user.
+type UserStats struct {
+	TotalGames        int64      `json:"total_games"`
+	Wins              int64      `json:"wins"`
+	Losses            int64      `json:"losses"`
+	WinRate           float64    `json:"win_rate"`
+	TotalPointsGained int64      `json:"total_points_gained"`
+	AvgPointsGained   float64    `json:"average_points_gained"`
+	FavouriteGameType int8       `json:"favourite_game_type,omitempty"`
+	LastPlayed        *time.Time `json:"last_played,omitempty"`
+}
+
+// Filter narrows down a users listing, zero values leave a field unrestricted.
+// LastName matches a case-insensitive prefix, the other strings match exactly.
+// The birth date range includes BornFrom and excludes BornTo.
+type Filter struct {
+	LastName string
+	Email    string
+	Country  string
+	City     string
+	Gender   string
+	BornFrom time.Time
+	BornTo   time.Time
+}
+
+// SortFields are the user fields a listing can be sorted by.
+var SortFields = map[string]bool{
+	"last_name":  true,
+	"email":      true,
+	"country":    true,
+	"city":       true,
+	"gender":     true,
+	"birth_date": true,
+}
+
+// Sort orders a users listing by Field, ties are broken by id. The zero value
+// orders by id only.
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// SearchQuery is a full-text search of the users. IncludeEmail matches and
+// scores the email too, for callers allowed to see personal data, otherwise a
+// search could reveal whose address it is.
+type SearchQuery struct {
+	Text         string
+	IncludeEmail bool
+}
+
+// SearchTokens splits text into the lower case words a search matches, so an
+// email address yields its local part and domain labels.
+func SearchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchResult is a user found by full-text search, more relevant users score higher.
+type SearchResult struct {
+	User  User    `json:"user"`
+	Score float64 `json:"score"`
 }
 
 type UserRating struct {
+	Rank   int64 `json:"rank,omitempty"`
 	User   User  `json:"user"`
 	Rating int64 `json:"rating"`
 }
+
+// UserRank is the position of a user in the users rating, ordered the same
+// way as /api/users-rating. Percentile is the share of users ranked at or
+// below the user. Above and Below hold the nearest neighbours in rating order.
+type UserRank struct {
+	Rank       int64        `json:"rank"`
+	Percentile float64      `json:"percentile"`
+	User       User         `json:"user"`
+	Rating     int64        `json:"rating"`
+	Above      []UserRating `json:"above"`
+	Below      []UserRating `json:"below"`
+}
+
+const (
+	MetricGames   = "games"
+	MetricPoints  = "points"
+	MetricWins    = "wins"
+	MetricWinRate = "win_rate"
+
+	WindowDaily   = "daily"
+	WindowWeekly  = "weekly"
+	WindowAllTime = "all"
+)
+
+// LeaderboardQuery selects how players are ranked: by Metric over the games
+// of GameType played since Since, among the players of Country and City.
+// Zero values leave a field unrestricted.
+type LeaderboardQuery struct {
+	Metric   string
+	GameType *int8
+	Country  string
+	City     string
+	Since    time.Time
+}
+
+// WindowStart returns the start of the current window in UTC, zero for all time.
+// Weekly windows start on Monday.
+func WindowStart(window string, now time.Time) time.Time {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch window {
+	case WindowDaily:
+		return today
+	case WindowWeekly:
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	}
+	return time.Time{}
+}
+
+type LeaderboardEntry struct {
+	Rank    int64   `json:"rank"`
+	User    User    `json:"user"`
+	Games   int64   `json:"games"`
+	Wins    int64   `json:"wins"`
+	Points  int64   `json:"points"`
+	WinRate float64 `json:"win_rate"`
+}
@@ -4,26 +4,36 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"strings"
 
 	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var _ Service = &service{}
 
 type Service interface {
 	GetById(ctx context.Context, uuid string) (User, error)
-	GetByName(ctx context.Context, lastName string) (User, error)
-	GetAll(ctx context.Context, limit, page int64) ([]User, error)
-	GetUsersRating(ctx context.Context, limit, page int64) ([]UserRating, error)
+	GetStats(ctx context.Context, uuid string) (UserStats, error)
+	GetAll(ctx context.Context, filter Filter, sort Sort, p pagination.Params) (pagination.Page, error)
+	Search(ctx context.Context, query SearchQuery, p pagination.Params) (pagination.Page, error)
+	GetUsersRating(ctx context.Context, p pagination.Params) (pagination.Page, error)
+	GetLeaderboard(ctx context.Context, query LeaderboardQuery, p pagination.Params) (pagination.Page, error)
+	GetRank(ctx context.Context, uuid string, neighbours int64) (UserRank, error)
+	Create(ctx context.Context, dto UserDTO) (string, error)
+	Update(ctx context.Context, uuid string, dto UserDTO) error
+	PartiallyUpdate(ctx context.Context, uuid string, dto UserDTO) error
+	Delete(ctx context.Context, uuid string) error
 }
 
 type service struct {
 	storage Storage
-	logger  *log.Logger
+	logger  *logging.Logger
 }
 
-func NewService(storage Storage, logger *log.Logger) (Service, error) {
+func NewService(storage Storage, logger *logging.Logger) (Service, error) {
 	return &service{
 		storage: storage,
 		logger:  logger,
@@ -33,7 +43,7 @@ func NewService(storage Storage, logger *log.Logger) (Service, error) {
 func (s service) GetById(ctx context.Context, uuid string) (user User, err error) {
 	user, err = s.storage.FindById(ctx, uuid)
 	if err != nil {
-		if errors.Is(err, apperror.ErrNotFound.Err) {
+		if errors.Is(err, apperror.ErrNotFound) {
 			return user, err
 		}
 		return user, fmt.Errorf("failed to find user by uuid. error: %w", err)
@@ -41,41 +51,178 @@ func (s service) GetById(ctx context.Context, uuid string) (user User, err error
 	return user, nil
 }
 
-func (s service) GetByName(ctx context.Context, lastName string) (user User, err error) {
-	user, err = s.storage.FindByName(ctx, lastName)
+func (s service) GetStats(ctx context.Context, uuid string) (stats UserStats, err error) {
+	stats, err = s.storage.AggregateUserStats(ctx, uuid)
 	if err != nil {
 		if errors.Is(err, apperror.ErrNotFound) {
-			return user, err
+			return stats, err
 		}
-		return user, fmt.Errorf("failed to get users by uuid. error: %w", err)
+		return stats, fmt.Errorf("failed to get user stats. error: %w", err)
 	}
-	return user, nil
+	return stats, nil
 }
 
-func (s service) GetAll(ctx context.Context, limit, page int64) (users []User, err error) {
-	users, err = s.storage.FindAll(ctx, limit, page)
+func (s service) GetAll(ctx context.Context, filter Filter, sort Sort, p pagination.Params) (page pagination.Page, err error) {
+	if p.Keyset && sort.Field != "" {
+		return page, apperror.BadRequestError("cursor pagination supports only the default sort")
+	}
+
+	users, nextCursor, err := s.storage.FindAll(ctx, filter, sort, p)
 	if err != nil {
 		if errors.Is(err, apperror.ErrNotFound) {
-			return users, err
+			return page, err
 		}
-		return users, fmt.Errorf("failed to get all users. error: %w", err)
+		return page, fmt.Errorf("failed to get all users. error: %w", err)
+	}
+
+	total, err := s.storage.Count(ctx, filter)
+	if err != nil {
+		return page, fmt.Errorf("failed to count users. error: %w", err)
+	}
+
+	if users == nil {
+		users = []User{}
+	}
+	return pagination.Page{Items: users, Total: total, NextCursor: nextCursor}, nil
+}
+
+func (s service) Search(ctx context.Context, query SearchQuery, p pagination.Params) (page pagination.Page, err error) {
+	if strings.TrimSpace(query.Text) == "" {
+		return page, apperror.BadRequestError("q query parameter is required")
+	}
+	if p.Keyset {
+		return page, apperror.BadRequestError("search supports only limit and page pagination")
 	}
-	if len(users) == 0 {
-		return users, apperror.ErrNotFound
+
+	results, total, err := s.storage.Search(ctx, query, p)
+	if err != nil {
+		return page, fmt.Errorf("failed to search users. error: %w", err)
+	}
+
+	if results == nil {
+		results = []SearchResult{}
 	}
-	return users, nil
+	return pagination.Page{Items: results, Total: total}, nil
 }
 
-func (s service) GetUsersRating(ctx context.Context, limit, page int64) (usersRatings []UserRating, err error) {
-	usersRatings, err = s.storage.AggregateRatingUsers(ctx, limit, page)
+func (s service) GetUsersRating(ctx context.Context, p pagination.Params) (page pagination.Page, err error) {
+	usersRatings, nextCursor, err := s.storage.AggregateRatingUsers(ctx, p)
 	if err != nil {
 		if errors.Is(err, apperror.ErrNotFound) {
-			return usersRatings, err
+			return page, err
 		}
-		return usersRatings, fmt.Errorf("failed to get statistics games. error: %w", err)
+		return page, fmt.Errorf("failed to get statistics games. error: %w", err)
 	}
-	if len(usersRatings) == 0 {
-		return usersRatings, apperror.ErrNotFound
+
+	total, err := s.storage.Count(ctx, Filter{})
+	if err != nil {
+		return page, fmt.Errorf("failed to count users. error: %w", err)
+	}
+
+	if usersRatings == nil {
+		usersRatings = []UserRating{}
+	}
+	return pagination.Page{Items: usersRatings, Total: total, NextCursor: nextCursor}, nil
+}
+
+func (s service) GetLeaderboard(ctx context.Context, query LeaderboardQuery, p pagination.Params) (page pagination.Page, err error) {
+	if p.Keyset {
+		return page, apperror.BadRequestError("leaderboards support only limit and page pagination")
+	}
+
+	entries, total, err := s.storage.AggregateLeaderboard(ctx, query, p)
+	if err != nil {
+		return page, fmt.Errorf("failed to get leaderboard. error: %w", err)
+	}
+
+	if entries == nil {
+		entries = []LeaderboardEntry{}
+	}
+	return pagination.Page{Items: entries, Total: total}, nil
+}
+
+func (s service) GetRank(ctx context.Context, uuid string, neighbours int64) (rank UserRank, err error) {
+	rank, err = s.storage.FindRank(ctx, uuid, neighbours)
+	if err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return rank, err
+		}
+		return rank, fmt.Errorf("failed to get user rank. error: %w", err)
+	}
+
+	total, err := s.storage.Count(ctx, Filter{})
+	if err != nil {
+		return rank, fmt.Errorf("failed to count users. error: %w", err)
+	}
+	// The estimated count may lag behind the rank right after inserts.
+	if total < rank.Rank {
+		total = rank.Rank
+	}
+	rank.Percentile = float64(total-rank.Rank+1) / float64(total) * 100
+
+	if rank.Above == nil {
+		rank.Above = []UserRating{}
+	}
+	if rank.Below == nil {
+		rank.Below = []UserRating{}
+	}
+	return rank, nil
+}
+
+func (s service) Create(ctx context.Context, dto UserDTO) (uuid string, err error) {
+	if fields := dto.Validate(false); fields != nil {
+		return uuid, apperror.ValidationError(fields)
+	}
+	uuid, err = s.storage.Create(ctx, dto.toUser())
+	if err != nil {
+		return uuid, fmt.Errorf("failed to create user. error: %w", err)
+	}
+	return uuid, nil
+}
+
+func (s service) Update(ctx context.Context, uuid string, dto UserDTO) error {
+	if fields := dto.Validate(false); fields != nil {
+		return apperror.ValidationError(fields)
+	}
+	return s.update(ctx, uuid, dto)
+}
+
+func (s service) PartiallyUpdate(ctx context.Context, uuid string, dto UserDTO) error {
+	if fields := dto.Validate(true); fields != nil {
+		return apperror.ValidationError(fields)
+	}
+	if dto == (UserDTO{}) {
+		return apperror.BadRequestError("at least one user field is required")
+	}
+	return s.update(ctx, uuid, dto)
+}
+
+func (s service) update(ctx context.Context, uuid string, dto UserDTO) error {
+	userId, err := primitive.ObjectIDFromHex(uuid)
+	if err != nil {
+		return apperror.ErrInvalidID
+	}
+	user := dto.toUser()
+	user.UUID = userId
+
+	if err = s.storage.Update(ctx, user); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to update user. error: %w", err)
+	}
+	return nil
+}
+
+func (s service) Delete(ctx context.Context, uuid string) error {
+	if !primitive.IsValidObjectID(uuid) {
+		return apperror.ErrInvalidID
+	}
+	if err := s.storage.Delete(ctx, uuid); err != nil {
+		if errors.Is(err, apperror.ErrNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to delete user. error: %w", err)
 	}
-	return usersRatings, nil
+	return nil
 }
@@ -2,11 +2,47 @@ package user
 
 import (
 	"context"
+
+	"github.com/IvanKyrylov/user-game-api/internal/pagination"
 )
 
 type Storage interface {
 	FindById(ctx context.Context, uuid string) (User, error)
-	FindByName(ctx context.Context, lastName string) (User, error)
-	FindAll(ctx context.Context, limit, page int64) ([]User, error)
-	AggregateRatingUsers(ctx context.Context, limit, page int64) ([]UserRating, error)
+	// FindAll and AggregateRatingUsers return the next cursor when p.Keyset is set and more items follow.
+	// FindAll supports keyset pagination only with the zero Sort.
+	FindAll(ctx context.Context, filter Filter, sort Sort, p pagination.Params) ([]User, string, error)
+	AggregateRatingUsers(ctx context.Context, p pagination.Params) ([]UserRating, string, error)
+	// Search matches the query against the last name, city and country of the
+	// users, and the email when query.IncludeEmail is set, and returns the
+	// requested page ordered by relevance with the number of matches.
+	Search(ctx context.Context, query SearchQuery, p pagination.Params) ([]SearchResult, int64, error)
+	// AggregateUserStats summarizes the games of the user from the user games collection.
+	AggregateUserStats(ctx context.Context, uuid string) (UserStats, error)
+	// AggregateLeaderboard ranks the players from the user games collection
+	// and returns the requested page together with the number of ranked players.
+	AggregateLeaderboard(ctx context.Context, query LeaderboardQuery, p pagination.Params) ([]LeaderboardEntry, int64, error)
+	// FindRank returns the rank of the user in the users rating together with
+	// up to neighbours users above and below. Percentile is left to the caller.
+	FindRank(ctx context.Context, uuid string, neighbours int64) (UserRank, error)
+	// Count returns the number of users matching filter, estimated when filter is empty.
+	Count(ctx context.Context, filter Filter) (int64, error)
+	Create(ctx context.Context, user User) (string, error)
+	Update(ctx context.Context, user User) error
+	// Delete removes the user with their games, ErrNotFound when there is no
+	// such user.
+	Delete(ctx context.Context, uuid string) error
+	// CreateAccount stores a user with a password, ErrConflict when an account
+	// with the same email exists.
+	CreateAccount(ctx context.Context, account Account) (string, error)
+	// FindAccount and FindAccountById return ErrNotFound for users without a password.
+	FindAccount(ctx context.Context, email string) (Account, error)
+	FindAccountById(ctx context.Context, uuid string) (Account, error)
+	// SetPassword replaces the password hash and ends all sessions of the account.
+	SetPassword(ctx context.Context, uuid, hash string) error
+	// AddSession stores session, keeping the MaxSessions latest sessions.
+	AddSession(ctx context.Context, uuid string, session Session) error
+	// ReplaceSession swaps the unexpired session id for session, ErrNotFound
+	// when the account has no such session.
+	ReplaceSession(ctx context.Context, uuid, id string, session Session) error
+	DeleteSession(ctx context.Context, uuid, id string) error
 }
@@ -3,52 +3,141 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/IvanKyrylov/user-game-api/internal/apperror"
+	"github.com/IvanKyrylov/user-game-api/internal/auth"
 	"github.com/IvanKyrylov/user-game-api/internal/config"
 	"github.com/IvanKyrylov/user-game-api/internal/game"
 	gamedb "github.com/IvanKyrylov/user-game-api/internal/game/db"
+	gamememory "github.com/IvanKyrylov/user-game-api/internal/game/memory"
+	handler "github.com/IvanKyrylov/user-game-api/internal/handlers"
+	"github.com/IvanKyrylov/user-game-api/internal/memdb"
 	"github.com/IvanKyrylov/user-game-api/internal/user"
 
 	userdb "github.com/IvanKyrylov/user-game-api/internal/user/db"
+	usermemory "github.com/IvanKyrylov/user-game-api/internal/user/memory"
+	"github.com/IvanKyrylov/user-game-api/pkg/health"
+	"github.com/IvanKyrylov/user-game-api/pkg/jwt"
 	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"github.com/IvanKyrylov/user-game-api/pkg/metrics"
 	mongo "github.com/IvanKyrylov/user-game-api/pkg/mongodb"
+	"github.com/IvanKyrylov/user-game-api/pkg/router"
 	"github.com/IvanKyrylov/user-game-api/pkg/shutdown"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
+
 // TEST DEV
 // Test Home
 func main() {
-	logger := logging.Init()
-	logging.CommonLog.Println("logger init")
+	configPath := flag.String("config", "config.yml", "path to the config file")
+	flag.Parse()
 
-	logging.CommonLog.Println("config init")
-	cfg := config.GetConfig()
+	logging.Default().Info("config init")
+	cfg := config.GetConfig(*configPath)
 
-	logging.CommonLog.Println("router init")
-	router := http.NewServeMux()
+	logger, err := logging.Init(cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		logging.Default().Fatal("failed to init logger", "error", err)
+	}
 
-	mongoClient, err := mongo.NewClient(context.Background(), cfg.MongoDB.Host, cfg.MongoDB.Port,
-		cfg.MongoDB.Username, cfg.MongoDB.Password, cfg.MongoDB.Database, cfg.MongoDB.AuthDB)
+	if err = apperror.SetFormat(cfg.ErrorFormat); err != nil {
+		logger.Fatal("failed to set error format", "error", err)
+	}
 
-	if err != nil {
-		logging.ErrorLog.Fatal(err)
+	logger.Info("router init")
+	// publicRouter serves the routes issuing tokens without authentication.
+	publicRouter := router.New()
+	publicRouter.NotFound(apperror.NotFoundHandler())
+	publicRouter.MethodNotAllowed(apperror.MethodNotAllowedHandler)
+	// opsRouter serves the operational endpoints next to the API, without
+	// authentication.
+	opsRouter := router.New()
+	opsRouter.NotFound(apperror.NotFoundHandler())
+	opsRouter.MethodNotAllowed(apperror.MethodNotAllowedHandler)
+	router := router.New()
+	router.NotFound(apperror.NotFoundHandler())
+	router.MethodNotAllowed(apperror.MethodNotAllowedHandler)
+
+	var registry *metrics.Registry
+	if cfg.Metrics.Enabled {
+		logger.Info("metrics init")
+		registry = metrics.NewRegistry()
 	}
 
-	// mongo.Migrate(mongoClient, logger)
+	// checks decide the readiness, subsystems register theirs as they start.
+	checks := health.NewRegistry(cfg.Health.Timeout)
 
-	userStorage := userdb.NewStorage(mongoClient, cfg.MongoDB.CollectionUsers, logger)
-	gameStorage := gamedb.NewStorage(mongoClient, cfg.MongoDB.CollectionUserGames, logger)
+	var userStorage user.Storage
+	var gameStorage game.Storage
 
-	if err != nil {
-		panic(err)
+	switch cfg.Storage {
+	case config.StorageMemory:
+		logger.Info("in-memory storage init")
+		memDB := memdb.New()
+		userStorage = usermemory.NewStorage(memDB, logger)
+		gameStorage = gamememory.NewStorage(memDB, logger)
+	case config.StorageMongoDB:
+		clientOptions := options.Client()
+		if registry != nil {
+			clientOptions.SetPoolMonitor(mongo.NewPoolMonitor(registry))
+		}
+		mongoClient, err := mongo.NewClient(context.Background(), mongo.Config{
+			URI:                    cfg.MongoDB.URI,
+			Host:                   cfg.MongoDB.Host,
+			Port:                   cfg.MongoDB.Port,
+			Username:               cfg.MongoDB.Username,
+			Password:               cfg.MongoDB.Password,
+			AuthSource:             cfg.MongoDB.AuthDB,
+			Database:               cfg.MongoDB.Database,
+			MaxPoolSize:            cfg.MongoDB.MaxPoolSize,
+			MinPoolSize:            cfg.MongoDB.MinPoolSize,
+			ConnectTimeout:         cfg.MongoDB.ConnectTimeout,
+			ServerSelectionTimeout: cfg.MongoDB.ServerSelectionTimeout,
+			SocketTimeout:          cfg.MongoDB.SocketTimeout,
+			TLS: mongo.TLSConfig{
+				Enabled:            cfg.MongoDB.TLS.Enabled,
+				CAFile:             cfg.MongoDB.TLS.CAFile,
+				CertificateKeyFile: cfg.MongoDB.TLS.CertificateKeyFile,
+				InsecureSkipVerify: cfg.MongoDB.TLS.InsecureSkipVerify,
+			},
+		}, clientOptions)
+
+		if err != nil {
+			logger.Fatal("failed to connect to mongodb", "error", err)
+		}
+
+		// mongo.Migrate(mongoClient, cfg.MongoDB.CollectionUsers, cfg.MongoDB.CollectionUserGames, logger)
+
+		if err = mongo.CreateIndexes(mongoClient, cfg.MongoDB.CollectionUsers, cfg.MongoDB.CollectionUserGames); err != nil {
+			logger.Fatal("failed to create indexes", "error", err)
+		}
+		checks.Register("mongodb", func(ctx context.Context) error {
+			return mongo.Ping(ctx, mongoClient)
+		})
+		checks.Register("migrations", func(ctx context.Context) error {
+			return mongo.CheckIndexes(ctx, mongoClient, cfg.MongoDB.CollectionUsers, cfg.MongoDB.CollectionUserGames)
+		})
+
+		userStorage = userdb.NewStorage(mongoClient, cfg.MongoDB.CollectionUsers, cfg.MongoDB.CollectionUserGames, logger)
+		gameStorage = gamedb.NewStorage(mongoClient, cfg.MongoDB.CollectionUserGames, cfg.MongoDB.CollectionUsers, logger)
+	default:
+		logger.Fatal(fmt.Sprintf("unknown storage %q, use %q or %q", cfg.Storage, config.StorageMongoDB, config.StorageMemory))
+	}
+
+	if registry != nil {
+		storageMetrics := metrics.NewStorageMetrics(registry)
+		userStorage = user.NewInstrumentedStorage(userStorage, storageMetrics)
+		gameStorage = game.NewInstrumentedStorage(gameStorage, storageMetrics)
 	}
 
 	userService, err := user.NewService(userStorage, logger)
@@ -63,50 +152,135 @@ func main() {
 		panic(err)
 	}
 
+	gameHandler := game.Handler{
+		Logger:      logger,
+		GameService: gameService,
+	}
 	userHandler := user.Handler{
 		Logger:      logger,
 		UserService: userService,
 	}
-	gameHandler := game.Handler{
-		Logger:      logger,
-		GameService: gameService,
+
+	var key *jwt.Key
+	if cfg.Auth.Enabled && cfg.Auth.JWT.KeyFile != "" {
+		if key, err = jwt.LoadKey(cfg.Auth.JWT.Algorithm, cfg.Auth.JWT.KeyFile); err != nil {
+			logger.Fatal("failed to load jwt key", "error", err)
+		}
+	}
+
+	handlers := []handler.Handler{&userHandler, &gameHandler}
+	var accountHandler *user.AccountHandler
+	if cfg.Auth.Accounts.Enabled && key != nil && key.CanSign() {
+		logger.Info("accounts init")
+		issuer := auth.NewIssuer(key, cfg.Auth.JWT.Issuer, cfg.Auth.JWT.Audience, cfg.Auth.Accounts.AccessTTL)
+		accountService, err := user.NewAccountService(userStorage, issuer, cfg.Auth.Accounts.RefreshTTL, logger)
+		if err != nil {
+			panic(err)
+		}
+		accountHandler = &user.AccountHandler{
+			Logger:         logger,
+			AccountService: accountService,
+		}
+		handlers = append(handlers, accountHandler)
+	}
+
+	// The unversioned /api prefix is kept for existing clients.
+	prefixes := []string{"/api", "/api/v1"}
+	for _, prefix := range prefixes {
+		group := router.Group(prefix)
+		for _, h := range handlers {
+			h.Register(group)
+		}
+		if accountHandler != nil {
+			accountHandler.RegisterPublic(publicRouter.Group(prefix))
+		}
+	}
+
+	var server http.Handler = router
+	if cfg.Auth.Enabled {
+		logger.Info("auth init")
+		if key == nil && len(cfg.Auth.APIKeys) == 0 {
+			logger.Fatal("auth is enabled, but neither api keys nor a jwt key file are configured")
+		}
+		for name, role := range cfg.Auth.APIKeyRoles {
+			if !auth.IsRole(role) {
+				logger.Fatal(fmt.Sprintf("unknown role %q of api key %q, use %q, %q or %q", role, name, auth.RolePublic, auth.RolePlayer, auth.RoleAdmin))
+			}
+		}
+		authenticator := auth.NewAuthenticator(cfg.Auth.APIKeys, cfg.Auth.APIKeyRoles, key, cfg.Auth.JWT.Issuer, cfg.Auth.JWT.Audience, logger)
+		// inside the router, so the metrics and the access log report the
+		// rejected requests under their route
+		router.Use(authenticator.Middleware)
+	}
+	if accountHandler != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/", server)
+		for _, prefix := range prefixes {
+			mux.Handle(prefix+user.AuthPath+"/", publicRouter)
+		}
+		server = mux
+	}
+
+	opsRouter.Handle(http.MethodGet, "/healthz", health.LiveHandler())
+	opsRouter.Handle(http.MethodGet, "/readyz", checks.ReadyHandler())
+	opsPaths := []string{"/healthz", "/readyz"}
+	if registry != nil {
+		if !strings.HasPrefix(cfg.Metrics.Path, "/") {
+			logger.Fatal(fmt.Sprintf("metrics path %q must start with /", cfg.Metrics.Path))
+		}
+		opsRouter.Handle(http.MethodGet, cfg.Metrics.Path, registry.Handler())
+		opsPaths = append(opsPaths, cfg.Metrics.Path)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", server)
+	for _, opsPath := range opsPaths {
+		mux.Handle(opsPath, opsRouter)
 	}
+	server = mux
 
-	userHandler.Register(router)
-	gameHandler.Register(router)
+	if registry != nil {
+		server = metrics.HTTPMiddleware(registry)(server)
+	}
+	if cfg.Log.Access.Enabled {
+		if cfg.Log.Access.SampleRate < 0 || cfg.Log.Access.SampleRate > 1 {
+			logger.Fatal("access log sample rate must be from 0 to 1")
+		}
+		server = logging.AccessLog(logger, logging.AccessLogOptions{
+			SampleRate: cfg.Log.Access.SampleRate,
+			Exclude:    cfg.Log.Access.Exclude,
+		})(server)
+	}
+	server = logging.RequestIDMiddleware(server)
 
-	logger.Println("Start application")
-	start(router, logger, cfg)
+	logger.Info("start application")
+	start(server, checks, logger, cfg)
 }
 
-func start(router http.Handler, logger *log.Logger, cfg *config.Config) {
+func start(router http.Handler, checks *health.Registry, logger *logging.Logger, cfg *config.Config) {
 	var server *http.Server
 	var listener net.Listener
 
 	if cfg.Listen.Type == "sock" {
 		appDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 		if err != nil {
-			logger.Fatal(err)
+			logger.Fatal("failed to find application dir", "error", err)
 		}
 		socketPath := path.Join(appDir, "app.sock")
-		logger.Printf("socket path: %s", socketPath)
 
-		logger.Println("create and listen unix socket")
+		logger.Info("create and listen unix socket", "path", socketPath)
 		listener, err = net.Listen("unix", socketPath)
 		if err != nil {
-			logger.Fatal(err)
+			logger.Fatal("failed to listen", "error", err)
 		}
 	} else {
-		// logger.Printf("bind application to host: %s and port: %s", cfg.Listen.BindIP, cfg.Listen.Port)
-		logger.Printf("bind application to host: %s and port: %s", "", os.Getenv("PORT"))
+		logger.Info("bind application", "host", cfg.Listen.BindIP, "port", cfg.Listen.Port)
 
 		var err error
 
-		// listener, err = net.Listen("tcp", fmt.Sprintf("%s:%s", cfg.Listen.BindIP, cfg.Listen.Port))
-		listener, err = net.Listen("tcp", fmt.Sprintf("%s:%s", "", os.Getenv("PORT")))
+		listener, err = net.Listen("tcp", net.JoinHostPort(cfg.Listen.BindIP, cfg.Listen.Port))
 
 		if err != nil {
-			logger.Fatal(err)
+			logger.Fatal("failed to listen", "error", err)
 		}
 	}
 
@@ -116,17 +290,24 @@ func start(router http.Handler, logger *log.Logger, cfg *config.Config) {
 		ReadTimeout:  15 * time.Second,
 	}
 
-	go shutdown.Graceful([]os.Signal{syscall.SIGABRT, syscall.SIGQUIT, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM},
-		server)
+	// /readyz fails first, the server keeps serving for the drain delay and
+	// then finishes the active requests.
+	stopped := make(chan struct{})
+	go func() {
+		shutdown.Graceful([]os.Signal{syscall.SIGABRT, syscall.SIGQUIT, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM},
+			checks, shutdown.Drain(cfg.Health.DrainDelay), shutdown.Server(server, server.WriteTimeout))
+		close(stopped)
+	}()
 
-	logger.Println("application initialized and started")
+	logger.Info("application initialized and started")
 
 	if err := server.Serve(listener); err != nil {
 		switch {
 		case errors.Is(err, http.ErrServerClosed):
-			logger.Println("server shutdown")
+			<-stopped
+			logger.Info("server shutdown")
 		default:
-			logger.Fatal(err)
+			logger.Fatal("server failed", "error", err)
 		}
 	}
 }
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// ErrShuttingDown fails the readiness once Close is called.
+var ErrShuttingDown = errors.New("shutting down")
+
+// CheckFunc reports whether a dependency is usable, ctx is cancelled after the
+// timeout of the registry.
+type CheckFunc func(ctx context.Context) error
+
+// Registry holds the readiness checks of the subsystems. The process is ready
+// when every check passes and it is not shutting down.
+type Registry struct {
+	mu           sync.Mutex
+	checks       map[string]CheckFunc
+	timeout      time.Duration
+	shuttingDown bool
+}
+
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{checks: make(map[string]CheckFunc), timeout: timeout}
+}
+
+// Register adds check under name, replacing a check of the same name.
+func (r *Registry) Register(name string, check CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// Close marks the process as shutting down, so it can be passed to
+// shutdown.Graceful ahead of the server.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shuttingDown = true
+	return nil
+}
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Check runs the checks concurrently and reports each of them.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]CheckFunc, len(names))
+	for i, name := range names {
+		checks[i] = r.checks[name]
+	}
+	shuttingDown := r.shuttingDown
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check CheckFunc) {
+			defer wg.Done()
+			errs[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(names)+1)}
+	for i, name := range names {
+		report.Checks[name] = result(errs[i])
+	}
+	if shuttingDown {
+		report.Checks["shutdown"] = result(ErrShuttingDown)
+	}
+	for _, check := range report.Checks {
+		if check.Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+func result(err error) CheckResult {
+	if err != nil {
+		return CheckResult{Status: StatusUnavailable, Error: err.Error()}
+	}
+	return CheckResult{Status: StatusOK}
+}
+
+// LiveHandler answers 200 as long as the process serves requests.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// ReadyHandler answers 200 when the registry is ready and 503 otherwise, with
+// the report of the checks in the body.
+func (r *Registry) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.Check(req.Context())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		write(w, status, report)
+	})
+}
+
+func write(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,83 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ready(t *testing.T, registry *Registry) (int, Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	registry.ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	return w.Code, report
+}
+
+func passing(ctx context.Context) error {
+	return nil
+}
+
+func TestReadyHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		check      CheckFunc
+		wantStatus int
+		wantError  string
+	}{
+		{"passing", passing, http.StatusOK, ""},
+		{"failing", func(ctx context.Context) error {
+			return errors.New("no primary")
+		}, http.StatusServiceUnavailable, "no primary"},
+		{"timed out", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, http.StatusServiceUnavailable, context.DeadlineExceeded.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry(10 * time.Millisecond)
+			registry.Register("other", passing)
+			registry.Register("dependency", tt.check)
+
+			status, report := ready(t, registry)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if want := result(nil); report.Checks["other"] != want {
+				t.Errorf("other = %+v, want %+v", report.Checks["other"], want)
+			}
+			check := report.Checks["dependency"]
+			if check.Error != tt.wantError || (tt.wantError == "") != (check.Status == StatusOK) {
+				t.Errorf("dependency = %+v, want error %q", check, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestReadyHandlerAfterClose(t *testing.T) {
+	registry := NewRegistry(time.Second)
+	registry.Register("dependency", passing)
+	if status, _ := ready(t, registry); status != http.StatusOK {
+		t.Fatalf("status before Close = %d, want 200", status)
+	}
+
+	registry.Close()
+	status, report := ready(t, registry)
+	if status != http.StatusServiceUnavailable || report.Status != StatusUnavailable {
+		t.Errorf("status after Close = %d %s, want 503 unavailable", status, report.Status)
+	}
+	if check := report.Checks["shutdown"]; check.Error != ErrShuttingDown.Error() {
+		t.Errorf("shutdown = %+v, want %v", check, ErrShuttingDown)
+	}
+	if check := report.Checks["dependency"]; check.Status != StatusOK {
+		t.Errorf("dependency = %+v, want ok", check)
+	}
+}
@@ -0,0 +1,250 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+var (
+	ErrMalformed = errors.New("token is malformed")
+	ErrAlgorithm = errors.New("token algorithm is not accepted")
+	ErrSignature = errors.New("token signature is invalid")
+	ErrExpired   = errors.New("token is expired")
+	ErrNotYet    = errors.New("token is not valid yet")
+	ErrIssuer    = errors.New("token issuer is not accepted")
+	ErrAudience  = errors.New("token audience is not accepted")
+	ErrNoSigning = errors.New("key can not sign tokens")
+)
+
+// Key verifies tokens of exactly one algorithm, so a token can not choose a
+// weaker one (or none) through its header. HS256 keys and RS256 keys loaded
+// from a private key also sign tokens.
+type Key struct {
+	algorithm string
+	secret    []byte
+	public    *rsa.PublicKey
+	private   *rsa.PrivateKey
+}
+
+// LoadKey reads the key of algorithm from path: the shared secret for HS256,
+// a PEM encoded public key, certificate or private key for RS256.
+func LoadKey(algorithm, path string) (*Key, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file. error: %w", err)
+	}
+
+	switch algorithm {
+	case HS256:
+		secret := []byte(strings.TrimSpace(string(bytes)))
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("HS256 secret must be at least 32 bytes")
+		}
+		return &Key{algorithm: algorithm, secret: secret}, nil
+	case RS256:
+		public, private, err := parseRSAKey(bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{algorithm: algorithm, public: public, private: private}, nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q, use %q or %q", algorithm, HS256, RS256)
+}
+
+// parseRSAKey returns the public key of the PEM block in bytes and, when the
+// block holds a private key, the private key.
+func parseRSAKey(bytes []byte) (*rsa.PublicKey, *rsa.PrivateKey, error) {
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, nil, fmt.Errorf("RS256 key file must be PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var certificate *x509.Certificate
+		if certificate, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = certificate.PublicKey
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse key. error: %w", err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return key, nil, nil
+	case *rsa.PrivateKey:
+		return &key.PublicKey, key, nil
+	}
+	return nil, nil, fmt.Errorf("RS256 key must be an RSA key")
+}
+
+// CanSign reports whether Sign can be used: always for HS256, for RS256 when
+// the key was loaded from a private key.
+func (k *Key) CanSign() bool {
+	return k.algorithm == HS256 || k.private != nil
+}
+
+// Sign encodes claims as the payload of a token signed with the key.
+func (k *Key) Sign(claims interface{}) (string, error) {
+	if !k.CanSign() {
+		return "", ErrNoSigning
+	}
+
+	h, err := json.Marshal(header{Algorithm: k.algorithm, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch k.algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case RS256:
+		digest := sha256.Sum256([]byte(signed))
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k.private, crypto.SHA256, digest[:]); err != nil {
+			return "", fmt.Errorf("failed to sign token. error: %w", err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Audience is the aud claim, a single string or an array of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Claims are the registered claims the API relies on, times are Unix seconds.
+type Claims struct {
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// Validate checks the token lifetime at now with leeway for clock skew and,
+// when not empty, the expected issuer and audience. The exp claim is required.
+func (c Claims) Validate(now time.Time, leeway time.Duration, issuer, audience string) error {
+	if c.ExpiresAt == 0 || now.Add(-leeway).Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Unix() < c.NotBefore {
+		return ErrNotYet
+	}
+	if issuer != "" && c.Issuer != issuer {
+		return ErrIssuer
+	}
+	if audience != "" {
+		for _, aud := range c.Audience {
+			if aud == audience {
+				return nil
+			}
+		}
+		return ErrAudience
+	}
+	return nil
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// Verify checks the token signature and decodes its payload into claims. The
+// claims are not validated, see Claims.Validate.
+func (k *Key) Verify(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return ErrMalformed
+	}
+	if h.Algorithm != k.algorithm {
+		return ErrAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+	signed := parts[0] + "." + parts[1]
+	switch k.algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrSignature
+		}
+	case RS256:
+		digest := sha256.Sum256([]byte(signed))
+		if err = rsa.VerifyPKCS1v15(k.public, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrSignature
+		}
+	}
+
+	if err = decode(parts[1], claims); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+func decode(part string, v interface{}) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
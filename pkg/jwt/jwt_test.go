@@ -0,0 +1,201 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadKey(t *testing.T, algorithm, content string) *Key {
+	t.Helper()
+	key, err := LoadKey(algorithm, writeFile(t, content))
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	return key
+}
+
+// rsaKeys returns PEM encoded private and public keys of a new RSA key.
+func rsaKeys(t *testing.T) (private, public string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	public = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	return private, public
+}
+
+func encode(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// hs256 signs the raw header and payload with secret, valid or not.
+func hs256(header, payload string) string {
+	signed := encode(header) + "." + encode(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestLoadKey(t *testing.T) {
+	private, public := rsaKeys(t)
+	tests := []struct {
+		name      string
+		algorithm string
+		content   string
+		wantErr   bool
+		canSign   bool
+	}{
+		{"hs256", HS256, secret + "\n", false, true},
+		{"hs256 short secret", HS256, secret[:31], true, false},
+		{"rs256 private", RS256, private, false, true},
+		{"rs256 public", RS256, public, false, false},
+		{"rs256 not pem", RS256, secret, true, false},
+		{"unknown algorithm", "none", secret, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := LoadKey(tt.algorithm, writeFile(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && key.CanSign() != tt.canSign {
+				t.Errorf("CanSign = %v, want %v", key.CanSign(), tt.canSign)
+			}
+		})
+	}
+
+	if _, err := loadKey(t, RS256, public).Sign(Claims{}); !errors.Is(err, ErrNoSigning) {
+		t.Errorf("Sign with a public key: err = %v, want ErrNoSigning", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	private, public := rsaKeys(t)
+	hsKey := loadKey(t, HS256, secret)
+	rsKey := loadKey(t, RS256, private)
+	rsPublic := loadKey(t, RS256, public)
+
+	hsToken, err := hsKey.Sign(Claims{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsToken, err := rsKey.Sign(Claims{Subject: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hsToken, ".")
+
+	tests := []struct {
+		name    string
+		key     *Key
+		token   string
+		wantErr error
+	}{
+		{"hs256", hsKey, hsToken, nil},
+		{"rs256", rsPublic, rsToken, nil},
+		{"wrong alg", hsKey, rsToken, ErrAlgorithm},
+		{"hs256 signed with the public key", rsPublic, hsToken, ErrAlgorithm},
+		{"none alg", hsKey, encode(`{"alg":"none"}`) + "." + parts[1] + ".", ErrAlgorithm},
+		{"tampered signature", hsKey, parts[0] + "." + parts[1] + "." + encode("signature"), ErrSignature},
+		{"tampered payload", hsKey, parts[0] + "." + encode(`{"sub":"admin"}`) + "." + parts[2], ErrSignature},
+		{"tampered rs256", rsPublic, rsToken + "A", ErrSignature},
+		{"two segments", hsKey, parts[0] + "." + parts[1], ErrMalformed},
+		{"four segments", hsKey, hsToken + ".", ErrMalformed},
+		{"header not base64", hsKey, "!." + parts[1] + "." + parts[2], ErrMalformed},
+		{"header not json", hsKey, encode("alg") + "." + parts[1] + "." + parts[2], ErrMalformed},
+		{"signature not base64", hsKey, parts[0] + "." + parts[1] + ".!", ErrMalformed},
+		{"payload not json", hsKey, hs256(`{"alg":"HS256"}`, "sub"), ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var claims Claims
+			err := tt.key.Verify(tt.token, &claims)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Subject != "alice" {
+				t.Errorf("subject = %q, want alice", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	leeway := 30 * time.Second
+	valid := Claims{Issuer: "issuer", Audience: Audience{"other", "api"}, ExpiresAt: now.Add(time.Minute).Unix()}
+	with := func(change func(c *Claims)) Claims {
+		c := valid
+		change(&c)
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		claims   Claims
+		issuer   string
+		audience string
+		wantErr  error
+	}{
+		{"valid", valid, "issuer", "api", nil},
+		{"issuer and audience not checked", valid, "", "", nil},
+		{"missing exp", with(func(c *Claims) { c.ExpiresAt = 0 }), "", "", ErrExpired},
+		{"expired", with(func(c *Claims) { c.ExpiresAt = now.Add(-time.Minute).Unix() }), "", "", ErrExpired},
+		{"expired within leeway", with(func(c *Claims) { c.ExpiresAt = now.Add(-10 * time.Second).Unix() }), "", "", nil},
+		{"nbf in the future", with(func(c *Claims) { c.NotBefore = now.Add(time.Minute).Unix() }), "", "", ErrNotYet},
+		{"nbf within leeway", with(func(c *Claims) { c.NotBefore = now.Add(10 * time.Second).Unix() }), "", "", nil},
+		{"issuer mismatch", valid, "other issuer", "", ErrIssuer},
+		{"audience mismatch", valid, "", "web", ErrAudience},
+		{"no audience", with(func(c *Claims) { c.Audience = nil }), "", "api", ErrAudience},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.claims.Validate(now, leeway, tt.issuer, tt.audience); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAudienceUnmarshal(t *testing.T) {
+	for payload, want := range map[string]string{
+		`{"aud":"api"}`:         "api",
+		`{"aud":["web","api"]}`: "web,api",
+	} {
+		var claims Claims
+		if err := decode(encode(payload), &claims); err != nil {
+			t.Fatalf("decode %s: %v", payload, err)
+		}
+		if got := strings.Join(claims.Audience, ","); got != want {
+			t.Errorf("audience of %s = %q, want %q", payload, got, want)
+		}
+	}
+}
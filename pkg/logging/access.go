@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/pkg/router"
+)
+
+// AccessLogOptions select the requests written to the access log. SampleRate
+// is the logged fraction of the requests answered below 500, server errors
+// are always logged. Exclude lists paths not logged at all, an entry ending
+// with * matches the paths starting with the rest of it.
+type AccessLogOptions struct {
+	SampleRate float64
+	Exclude    []string
+}
+
+type annotationsKey struct{}
+
+type annotations struct {
+	sync.Mutex
+	keyvals []interface{}
+}
+
+// Annotate adds keyvals to the access log entry of the request of ctx, e.g.
+// the authenticated caller. It does nothing outside AccessLog.
+func Annotate(ctx context.Context, keyvals ...interface{}) {
+	if a, ok := ctx.Value(annotationsKey{}).(*annotations); ok {
+		a.Lock()
+		a.keyvals = append(a.keyvals, keyvals...)
+		a.Unlock()
+	}
+}
+
+// AccessLog returns a middleware writing an entry per request to logger with
+// the method, route pattern, path, status, response bytes, duration, remote
+// address and request ID. The route pattern is empty when no route matched.
+func AccessLog(logger *Logger, options AccessLogOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if excluded(options.Exclude, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			a := &annotations{}
+			r, route := router.TrackRoute(r.WithContext(context.WithValue(r.Context(), annotationsKey{}, a)))
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			level := LevelInfo
+			if recorder.status >= http.StatusInternalServerError {
+				level = LevelWarn
+			} else if options.SampleRate < 1 && rand.Float64() >= options.SampleRate {
+				return
+			}
+
+			keyvals := []interface{}{
+				"method", r.Method,
+				"route", route(),
+				"path", r.URL.Path,
+				"status", recorder.status,
+				"bytes", recorder.bytes,
+				"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"remote_addr", r.RemoteAddr,
+			}
+			a.Lock()
+			keyvals = append(keyvals, a.keyvals...)
+			a.Unlock()
+			logger.WithContext(r.Context()).log(level, "access", keyvals)
+		})
+	}
+}
+
+func excluded(exclude []string, path string) bool {
+	for _, pattern := range exclude {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder keeps the status and the number of body bytes of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
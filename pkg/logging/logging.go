@@ -1,30 +1,219 @@
 package logging
 
 import (
-	"log"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-var (
-	CommonLog *log.Logger
-	ErrorLog  *log.Logger
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
 )
 
-func Init() *log.Logger {
-	log.SetFlags(log.Ldate | log.Lmicroseconds | log.Llongfile)
+type Level int8
 
-	// err := os.MkdirAll("logs", 0755)
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+// Logger writes one JSON object or logfmt line per entry with the time, level,
+// message and key value pairs. Loggers derived with With share the output.
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	format string
+	level  Level
+	fields []interface{}
+}
+
+func New(out io.Writer, format string, level Level) (*Logger, error) {
+	if format != FormatJSON && format != FormatLogfmt {
+		return nil, fmt.Errorf("unknown log format %q, use %q or %q", format, FormatJSON, FormatLogfmt)
+	}
+	return &Logger{
+		mu:     &sync.Mutex{},
+		out:    out,
+		format: format,
+		level:  level,
+	}, nil
+}
+
+var defaultLogger = &Logger{mu: &sync.Mutex{}, out: os.Stdout, format: FormatJSON, level: LevelInfo}
+
+// Init replaces the default logger, which writes JSON at info level to stdout
+// until then, with one of format and level.
+func Init(format, level string) (*Logger, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := New(os.Stdout, format, l)
+	if err != nil {
+		return nil, err
+	}
+	defaultLogger = logger
+	return logger, nil
+}
+
+// Default returns the logger for code without a logger of its own.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// With returns a logger adding keyvals, alternating keys and values, to every entry.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append(make([]interface{}, 0, len(l.fields)+len(keyvals)), l.fields...), keyvals...)
+	return &child
+}
+
+// WithContext returns a logger adding the request ID of ctx, if any.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	if id := RequestID(ctx); id != "" {
+		return l.With("request_id", id)
+	}
+	return l
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
 
-	// if err != nil || os.IsExist(err) {
-	// 	panic("can't create log dir. no configured logging to files")
-	// } else {
-	// 	openLogfile, err := os.OpenFile("logs/info.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0660)
-	// 	if err != nil {
-	// 		log.Fatalf("error opening file: %v", err)
-	// 	}
-	CommonLog = log.New(os.Stdout, "Common Logger:\t", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLog = log.New(os.Stdout, "Error Logger:\t", log.Ldate|log.Ltime|log.Lshortfile)
-	return log.New(os.Stdout, "Default Logger:\t", log.Ldate|log.Ltime|log.Lshortfile)
-	// }
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// Fatal logs at error level and exits.
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	all := make([]interface{}, 0, 6+len(l.fields)+len(keyvals))
+	all = append(all, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	all = append(append(all, l.fields...), keyvals...)
+	if len(all)%2 != 0 {
+		all = append(all, "(missing)")
+	}
+
+	var buf bytes.Buffer
+	if l.format == FormatJSON {
+		writeJSON(&buf, all)
+	} else {
+		writeLogfmt(&buf, all)
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, keyvals []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(jsonValue(keyvals[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(keyvals[i+1]))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+// jsonValue turns errors, durations and other stringers into strings, which
+// json.Marshal would encode as {} or a number.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func writeLogfmt(buf *bytes.Buffer, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtValue(fmt.Sprint(keyvals[i])))
+		buf.WriteByte('=')
+
+		value := keyvals[i+1]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		buf.WriteString(logfmtValue(fmt.Sprint(value)))
+	}
+}
 
+// logfmtValue quotes s when it is empty or has spaces, quotes, equal signs
+// or control characters.
+func logfmtValue(s string) string {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == 0x7f
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
 }
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the request ID from the client or a proxy and back
+// in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the accepted IDs, longer ones are replaced.
+const maxRequestIDLength = 128
+
+type contextKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID returns the request ID put into ctx by RequestIDMiddleware.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware accepts the X-Request-ID of the request or generates
+// one, passes it to next in the request context and echoes it in the response.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so they can be
+// logged and echoed as they are.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(bytes)
+}
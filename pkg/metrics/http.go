@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/IvanKyrylov/user-game-api/pkg/router"
+)
+
+// unmatchedRoute labels the requests no route matched, so scans of random
+// paths do not create a series each.
+const unmatchedRoute = "unmatched"
+
+// HTTPMiddleware returns a middleware counting requests and observing their
+// duration per method, route pattern and status.
+func HTTPMiddleware(registry *Registry) func(http.Handler) http.Handler {
+	requests := registry.NewCounterVec("http_requests_total",
+		"Number of HTTP requests by method, route and status.", "method", "route", "status")
+	durations := registry.NewHistogramVec("http_request_duration_seconds",
+		"Duration of HTTP requests by method, route and status.", nil, "method", "route", "status")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			r, route := router.TrackRoute(r)
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			pattern := route()
+			if pattern == "" {
+				pattern = unmatchedRoute
+			}
+			status := strconv.Itoa(recorder.status)
+			requests.With(r.Method, pattern, status).Inc()
+			durations.With(r.Method, pattern, status).Observe(time.Since(start).Seconds())
+		})
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
+	"github.com/IvanKyrylov/user-game-api/pkg/router"
+)
+
+// TestHTTPMiddlewareWithAccessLog stacks both middlewares the way main does,
+// they have to see the same route pattern.
+func TestHTTPMiddlewareWithAccessLog(t *testing.T) {
+	rt := router.New()
+	rt.HandleFunc(http.MethodGet, "/users/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	registry := NewRegistry()
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.FormatJSON, logging.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := logging.AccessLog(logger, logging.AccessLogOptions{SampleRate: 1})(HTTPMiddleware(registry)(rt))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/abc", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nothing", nil))
+
+	type entry struct {
+		Route string `json:"route"`
+	}
+	var entries []entry
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("access log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 || entries[0].Route != "/users/{uuid}" || entries[1].Route != "" {
+		t.Errorf("access log routes = %+v, want /users/{uuid} and none", entries)
+	}
+
+	metrics := scrape(t, registry)
+	for _, series := range []string{
+		`http_requests_total{method="GET",route="/users/{uuid}",status="201"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(metrics, series+"\n") {
+			t.Errorf("metrics miss %s:\n%s", series, metrics)
+		}
+	}
+}
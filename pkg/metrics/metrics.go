@@ -0,0 +1,307 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are histogram upper bounds in seconds suited to request and
+// query latencies.
+var DefBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// Handler serves the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		r.mu.Lock()
+		families := append([]family(nil), r.families...)
+		r.mu.Unlock()
+		for _, f := range families {
+			f.write(buf)
+		}
+		buf.Flush()
+	})
+}
+
+// desc is what the families share: the name, help, type and label names.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// vec keeps the series of a family by their label values.
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]interface{}
+	values map[string][]string
+}
+
+func newVec(d desc) vec {
+	return vec{desc: d, series: make(map[string]interface{}), values: make(map[string][]string)}
+}
+
+// get returns the series of values, created by create on first use.
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls f with the label values and the series in a stable order.
+func (v *vec) each(f func(values []string, s interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]interface{}, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		series[i], values[i] = v.series[key], v.values[key]
+	}
+	v.mu.Unlock()
+
+	for i := range keys {
+		f(values[i], series[i])
+	}
+}
+
+// labels formats the label pairs of names and values, extra is appended as is.
+func labels(names, values []string, extra string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escape(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 guarded by a mutex, simple enough for the rates
+// of this API.
+type atomicFloat struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (a *atomicFloat) add(delta float64) {
+	a.mu.Lock()
+	a.value += delta
+	a.mu.Unlock()
+}
+
+func (a *atomicFloat) set(value float64) {
+	a.mu.Lock()
+	a.value = value
+	a.mu.Unlock()
+}
+
+func (a *atomicFloat) get() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.value
+}
+
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a counter family, name should end with _total.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(desc{name: name, help: help, kind: "counter", labels: labelNames})}
+	r.register(c)
+	return c
+}
+
+type Counter struct {
+	value atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add increases the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counter can not decrease")
+	}
+	c.value.add(delta)
+}
+
+// With returns the counter of the label values.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w)
+	c.each(func(values []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels(c.labels, values, ""), formatFloat(s.(*Counter).value.get()))
+	})
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(desc{name: name, help: help, kind: "gauge", labels: labelNames})}
+	r.register(g)
+	return g
+}
+
+type Gauge struct {
+	value atomicFloat
+}
+
+func (g *Gauge) Set(value float64) {
+	g.value.set(value)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.value.add(delta)
+}
+
+// With returns the gauge of the label values.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.get(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.header(w)
+	g.each(func(values []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels(g.labels, values, ""), formatFloat(s.(*Gauge).value.get()))
+	})
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram family with the sorted upper bounds
+// buckets, DefBuckets when nil.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{vec: newVec(desc{name: name, help: help, kind: "histogram", labels: labelNames}), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe records value, e.g. a duration in seconds.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// counts are not cumulative here, write adds them up
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+// With returns the histogram of the label values.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.get(values, func() interface{} {
+		return &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w)
+	h.each(func(values []string, s interface{}) {
+		histogram := s.(*Histogram)
+		histogram.mu.Lock()
+		counts := append([]uint64(nil), histogram.counts...)
+		count, sum := histogram.count, histogram.sum
+		histogram.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			le := `le="` + formatFloat(bound) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels(h.labels, values, le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels(h.labels, values, `le="+Inf"`), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels(h.labels, values, ""), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels(h.labels, values, ""), count)
+	})
+}
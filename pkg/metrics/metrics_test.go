@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func scrape(t *testing.T, registry *Registry) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	return w.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Number of requests.\nBy path.", "path")
+	inFlight := registry.NewGaugeVec("in_flight", "Requests in flight.")
+	durations := registry.NewHistogramVec("duration_seconds", `Duration with a \ in help.`, []float64{0.1, 1}, "path")
+
+	requests.With("/b").Inc()
+	requests.With("/a").Add(2)
+	requests.With(`/"quoted"\` + "\n").Inc()
+	inFlight.With().Set(3)
+	inFlight.With().Add(-1)
+	durations.With("/a").Observe(0.05)
+	durations.With("/a").Observe(0.1)
+	durations.With("/a").Observe(0.5)
+	durations.With("/a").Observe(2)
+
+	want := `# HELP requests_total Number of requests.\nBy path.
+# TYPE requests_total counter
+requests_total{path="/\"quoted\"\\\n"} 1
+requests_total{path="/a"} 2
+requests_total{path="/b"} 1
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 2
+# HELP duration_seconds Duration with a \\ in help.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{path="/a",le="0.1"} 2
+duration_seconds_bucket{path="/a",le="1"} 3
+duration_seconds_bucket{path="/a",le="+Inf"} 4
+duration_seconds_sum{path="/a"} 2.65
+duration_seconds_count{path="/a"} 4
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import "time"
+
+// StorageMetrics observe the calls of the storages per storage and method.
+type StorageMetrics struct {
+	durations *HistogramVec
+	errors    *CounterVec
+}
+
+func NewStorageMetrics(registry *Registry) *StorageMetrics {
+	return &StorageMetrics{
+		durations: registry.NewHistogramVec("storage_call_duration_seconds",
+			"Duration of storage calls by storage and method.", nil, "storage", "method"),
+		errors: registry.NewCounterVec("storage_call_errors_total",
+			"Number of failed storage calls by storage and method.", "storage", "method"),
+	}
+}
+
+// Observe records a call of method of storage started at start, e.g.
+// defer m.Observe("user", "FindById", time.Now(), &err).
+func (m *StorageMetrics) Observe(storage, method string, start time.Time, err *error) {
+	m.durations.With(storage, method).Observe(time.Since(start).Seconds())
+	if err != nil && *err != nil {
+		m.errors.With(storage, method).Inc()
+	}
+}
@@ -2,34 +2,157 @@ package mongo
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"os"
+	"io/ioutil"
+	"net"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-func NewClient(ctx context.Context, host, port, username, password, database, authSource string) (*mongo.Database, error) {
-	// mongoDBURL := fmt.Sprintf("mongodb://%s:%s@%s:%s", username, password, host, port)
-	// credentials := options.Credential{
-	// 	AuthSource:  authSource,
-	// 	Username:    username,
-	// 	Password:    password,
-	// 	PasswordSet: true,
-	// }
-	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+// defaultConnectTimeout bounds the connection and the first ping when
+// Config.ConnectTimeout is not set.
+const defaultConnectTimeout = 10 * time.Second
+
+// Config describes how to reach the server: URI as it is, credentials
+// included, or Host and Port with Username, Password and AuthSource when URI
+// is empty. Zero pool sizes and timeouts keep the driver defaults.
+type Config struct {
+	URI        string
+	Host       string
+	Port       string
+	Username   string
+	Password   string
+	AuthSource string
+	Database   string
+
+	MaxPoolSize            uint64
+	MinPoolSize            uint64
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+
+	TLS TLSConfig
+}
+
+type TLSConfig struct {
+	Enabled bool
+	// CAFile is a PEM bundle verifying the server, the system roots when empty.
+	CAFile string
+	// CertificateKeyFile is a PEM file with the client certificate and key.
+	CertificateKeyFile string
+	InsecureSkipVerify bool
+}
+
+// ClientOptions returns the driver options of cfg.
+func (cfg Config) ClientOptions() (*options.ClientOptions, error) {
+	var clientOptions *options.ClientOptions
+	switch {
+	case cfg.URI != "":
+		clientOptions = options.Client().ApplyURI(cfg.URI)
+	case cfg.Host != "":
+		clientOptions = options.Client().ApplyURI("mongodb://" + net.JoinHostPort(cfg.Host, cfg.Port))
+		if cfg.Username != "" {
+			clientOptions.SetAuth(options.Credential{
+				AuthSource: cfg.AuthSource,
+				Username:   cfg.Username,
+				Password:   cfg.Password,
+			})
+		}
+	default:
+		return nil, errors.New("mongodb uri or host is required")
+	}
+	if err := clientOptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxPoolSize > 0 && cfg.MinPoolSize > cfg.MaxPoolSize {
+		return nil, fmt.Errorf("mongodb min pool size %d exceeds max pool size %d", cfg.MinPoolSize, cfg.MaxPoolSize)
+	}
+	if cfg.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(cfg.MaxPoolSize)
+	}
+	if cfg.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(cfg.MinPoolSize)
+	}
+	if cfg.ConnectTimeout > 0 {
+		clientOptions.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+	if cfg.ServerSelectionTimeout > 0 {
+		clientOptions.SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+	}
+	if cfg.SocketTimeout > 0 {
+		clientOptions.SetSocketTimeout(cfg.SocketTimeout)
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := cfg.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	return clientOptions, nil
+}
+
+func (cfg TLSConfig) config() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mongodb ca file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in mongodb ca file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertificateKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertificateKeyFile, cfg.CertificateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load mongodb client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// NewClient connects to the server of cfg and pings it, opts are applied on
+// top of the options of cfg.
+func NewClient(ctx context.Context, cfg Config, opts ...*options.ClientOptions) (*mongo.Database, error) {
+	if cfg.Database == "" {
+		return nil, errors.New("mongodb database is required")
+	}
+	clientOptions, err := cfg.ClientOptions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client to mongodb due to error %w", err)
+	}
+
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	client, err := mongo.Connect(reqCtx, options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
-	// .SetAuth(credentials))
+	client, err := mongo.Connect(reqCtx, append([]*options.ClientOptions{clientOptions}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client to mongodb due to error %w", err)
 	}
 
-	err = client.Ping(context.Background(), nil)
+	err = client.Ping(reqCtx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client to mongodb due to error %w", err)
 	}
 
-	return client.Database(database), nil
+	return client.Database(cfg.Database), nil
+}
+
+// Ping checks that the primary of the client of database answers.
+func Ping(ctx context.Context, database *mongo.Database) error {
+	return database.Client().Ping(ctx, readpref.Primary())
 }
@@ -0,0 +1,47 @@
+package mongo
+
+import (
+	"github.com/IvanKyrylov/user-game-api/pkg/metrics"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// NewPoolMonitor returns a pool monitor keeping the connection pool stats of
+// the client in registry, per server address.
+func NewPoolMonitor(registry *metrics.Registry) *event.PoolMonitor {
+	maxSize := registry.NewGaugeVec("mongodb_pool_max_size",
+		"Maximum number of connections of the pool.", "address")
+	open := registry.NewGaugeVec("mongodb_pool_connections",
+		"Number of open connections of the pool.", "address")
+	inUse := registry.NewGaugeVec("mongodb_pool_connections_in_use",
+		"Number of connections checked out of the pool.", "address")
+	checkouts := registry.NewCounterVec("mongodb_pool_checkouts_total",
+		"Number of connections checked out of the pool.", "address")
+	checkoutFailures := registry.NewCounterVec("mongodb_pool_checkout_failures_total",
+		"Number of failed connection checkouts by reason.", "address", "reason")
+	cleared := registry.NewCounterVec("mongodb_pool_cleared_total",
+		"Number of times the pool was cleared.", "address")
+
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.PoolCreated:
+				if e.PoolOptions != nil {
+					maxSize.With(e.Address).Set(float64(e.PoolOptions.MaxPoolSize))
+				}
+			case event.ConnectionCreated:
+				open.With(e.Address).Add(1)
+			case event.ConnectionClosed:
+				open.With(e.Address).Add(-1)
+			case event.GetSucceeded:
+				checkouts.With(e.Address).Inc()
+				inUse.With(e.Address).Add(1)
+			case event.ConnectionReturned:
+				inUse.With(e.Address).Add(-1)
+			case event.GetFailed:
+				checkoutFailures.With(e.Address, e.Reason).Inc()
+			case event.PoolCleared:
+				cleared.With(e.Address).Inc()
+			}
+		},
+	}
+}
@@ -3,15 +3,19 @@ package mongo
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/IvanKyrylov/user-game-api/pkg/logging"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserJSON struct {
@@ -38,10 +42,10 @@ type userGameJSONRes struct {
 	Objects []UserGameJSON `json:"objects"`
 }
 
-func parseUsersJSON(log *log.Logger) []UserJSON {
+func parseUsersJSON(logger *logging.Logger) []UserJSON {
 	jsonFile, err := os.Open("resources/users_go.json")
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("migration failed", "error", err)
 	}
 	defer jsonFile.Close()
 
@@ -49,16 +53,16 @@ func parseUsersJSON(log *log.Logger) []UserJSON {
 	var fileRes usersJSONRes
 	err = json.Unmarshal(byteValue, &fileRes)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("migration failed", "error", err)
 	}
 
 	return fileRes.Objects
 }
 
-func parseUserGamesJSON(log *log.Logger) []UserGameJSON {
+func parseUserGamesJSON(logger *logging.Logger) []UserGameJSON {
 	jsonFile, err := os.Open("resources/games.json")
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("migration failed", "error", err)
 	}
 	defer jsonFile.Close()
 
@@ -66,21 +70,21 @@ func parseUserGamesJSON(log *log.Logger) []UserGameJSON {
 	var fileRes userGameJSONRes
 	err = json.Unmarshal(byteValue, &fileRes)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("migration failed", "error", err)
 	}
 
 	return fileRes.Objects
 }
 
-func insertUsers(client *mongo.Database, log *log.Logger) []interface{} {
-	users := parseUsersJSON(log)
-	userCollection := client.Collection("users")
-	log.Println("Started inserting users...")
+func insertUsers(client *mongo.Database, usersCollection string, logger *logging.Logger) []interface{} {
+	users := parseUsersJSON(logger)
+	userCollection := client.Collection(usersCollection)
+	logger.Info("started inserting users")
 	usersMongo := make([]interface{}, 0, len(users))
 	for _, user := range users {
 		birthDate, err := time.Parse("Monday, January 2, 2006 3:04 PM", user.BirthDate)
 		if err != nil {
-			log.Println(err)
+			logger.Warn("failed to parse birth date", "email", user.Email, "error", err)
 		}
 		validYear := rand.Intn(2015-1980) + 1980
 		validBirthDate := time.Date(validYear, birthDate.Month(), birthDate.Day(), birthDate.Hour(), birthDate.Minute(), birthDate.Second(), birthDate.Nanosecond(), birthDate.Location())
@@ -96,21 +100,20 @@ func insertUsers(client *mongo.Database, log *log.Logger) []interface{} {
 		usersMongo = append(usersMongo, newUser)
 	}
 	insertRes, _ := userCollection.InsertMany(context.Background(), usersMongo)
-	log.Printf("insertedUsers: %v", len(insertRes.InsertedIDs))
-	log.Println("Finished inserting users")
+	logger.Info("finished inserting users", "inserted", len(insertRes.InsertedIDs))
 	return insertRes.InsertedIDs
 }
 
-func insertUserGames(client *mongo.Database, log *log.Logger, userIds []interface{}) {
-	userGames := parseUserGamesJSON(log)
-	userGamesCollection := client.Collection("user_games")
+func insertUserGames(client *mongo.Database, usersCollection, gamesCollection string, logger *logging.Logger, userIds []interface{}) {
+	userGames := parseUserGamesJSON(logger)
+	userGamesCollection := client.Collection(gamesCollection)
 
-	log.Println("Started inserting user games...")
+	logger.Info("started inserting user games")
 	var foundUserIds = make([]primitive.ObjectID, 0, len(userIds))
 	for _, userID := range userIds {
 		idToObjectID, ok := userID.(primitive.ObjectID)
 		if !ok {
-			log.Println("Cannot cast userId to ObjectID")
+			logger.Warn("cannot cast user id to ObjectID")
 			continue
 		}
 		foundUserIds = append(foundUserIds, idToObjectID)
@@ -125,7 +128,7 @@ func insertUserGames(client *mongo.Database, log *log.Logger, userIds []interfac
 			randGame := userGames[rand.Intn(len(userGames))]
 			created, err := time.Parse("1/2/2006 3:04 PM", randGame.Created)
 			if err != nil {
-				log.Fatal(err)
+				logger.Fatal("migration failed", "error", err)
 				continue
 			}
 			var newUserGame = bson.D{
@@ -140,46 +143,147 @@ func insertUserGames(client *mongo.Database, log *log.Logger, userIds []interfac
 		}
 		_, err := userGamesCollection.InsertMany(context.Background(), randGames)
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal("migration failed", "error", err)
 		}
 
-		userCollection := client.Collection("users")
+		userCollection := client.Collection(usersCollection)
 		replacement := bson.D{{"$set", bson.D{{"rating", int64(len(randGames))}}}}
 		_, err = userCollection.UpdateOne(context.Background(), bson.D{{"_id", foundUserID}}, replacement)
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal("migration failed", "error", err)
 		}
 	}
-	log.Println("Inserted user games")
+	logger.Info("inserted user games")
 }
 
-func createIndexes(client *mongo.Database) (err error) {
+type collectionIndexes struct {
+	collection string
+	models     []mongo.IndexModel
+}
 
-	_, err = client.Collection("user_games").Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys: bson.M{
-				"user_id": 1,
+// indexes lists the indexes the storages rely on per collection, in the
+// order they are created, for the configured collection names.
+func indexes(usersCollection, gamesCollection string) []collectionIndexes {
+	return []collectionIndexes{
+		{
+			collection: gamesCollection,
+			models: []mongo.IndexModel{
+				{Keys: bson.M{"user_id": 1}},
+				// games listings sorted by created and their keyset pagination
+				{Keys: bson.D{{"created", -1}, {"_id", -1}}},
+				{Keys: bson.D{{"user_id", 1}, {"created", -1}, {"_id", -1}}},
 			},
 		},
-	)
-	if err != nil {
-		log.Fatal(err)
-		return
+		{
+			collection: usersCollection,
+			models: []mongo.IndexModel{
+				// rating sort and its keyset pagination
+				{Keys: bson.D{{"rating", -1}, {"_id", 1}}},
+				// users listing filters and sorts, ties are broken by _id
+				{Keys: bson.D{{"last_name", 1}, {"_id", 1}}},
+				{Keys: bson.D{{"email", 1}, {"_id", 1}}},
+				{Keys: bson.D{{"country", 1}, {"city", 1}, {"_id", 1}}},
+				{Keys: bson.D{{"city", 1}, {"_id", 1}}},
+				{Keys: bson.D{{"gender", 1}, {"_id", 1}}},
+				{Keys: bson.D{{"birth_date", 1}, {"_id", 1}}},
+				// login by email, unique among the users who signed up with a password
+				{
+					Keys: bson.D{{"email", 1}},
+					Options: options.Index().SetName("users_account_email").SetUnique(true).
+						SetPartialFilterExpression(bson.M{"password_hash": bson.M{"$exists": true}}),
+				},
+				// full-text search, a collection has at most one text index
+				{
+					Keys: bson.D{{"last_name", "text"}, {"email", "text"}, {"city", "text"}, {"country", "text"}},
+					Options: options.Index().SetName("users_text").SetWeights(bson.D{
+						{"last_name", 10},
+						{"email", 5},
+						{"city", 2},
+						{"country", 1},
+					}),
+				},
+			},
+		},
+	}
+}
+
+// CreateIndexes creates the indexes the storages rely on in the users and
+// games collections. Creating an index that already exists is a no-op, so it
+// is safe to call on every start.
+func CreateIndexes(client *mongo.Database, usersCollection, gamesCollection string) error {
+	for _, index := range indexes(usersCollection, gamesCollection) {
+		if _, err := client.Collection(index.collection).Indexes().CreateMany(context.Background(), index.models); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// CheckIndexes returns an error naming the first index created by
+// CreateIndexes that is missing, e.g. because it was dropped.
+func CheckIndexes(ctx context.Context, client *mongo.Database, usersCollection, gamesCollection string) error {
+	for _, index := range indexes(usersCollection, gamesCollection) {
+		specs, err := client.Collection(index.collection).Indexes().ListSpecifications(ctx)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]bool, len(specs))
+		for _, spec := range specs {
+			existing[spec.Name] = true
+		}
+		for _, model := range index.models {
+			name, err := indexName(model)
+			if err != nil {
+				return err
+			}
+			if !existing[name] {
+				return fmt.Errorf("index %s of collection %s is missing", name, index.collection)
+			}
+		}
+	}
 	return nil
 }
 
-func Migrate(client *mongo.Database, log *log.Logger) {
+// indexName returns the name of model, generated the way the driver does it
+// when the options do not set one, e.g. rating_-1__id_1.
+func indexName(model mongo.IndexModel) (string, error) {
+	if model.Options != nil && model.Options.Name != nil {
+		return *model.Options.Name, nil
+	}
+	keys, err := bson.Marshal(model.Keys)
+	if err != nil {
+		return "", err
+	}
+	elements, err := bson.Raw(keys).Elements()
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(elements))
+	for _, element := range elements {
+		value := element.Value()
+		switch value.Type {
+		case bsontype.Int32:
+			parts = append(parts, fmt.Sprintf("%s_%d", element.Key(), value.Int32()))
+		case bsontype.Int64:
+			parts = append(parts, fmt.Sprintf("%s_%d", element.Key(), value.Int64()))
+		case bsontype.String:
+			parts = append(parts, element.Key()+"_"+value.StringValue())
+		default:
+			return "", fmt.Errorf("unsupported key %s of an unnamed index", element.Key())
+		}
+	}
+	return strings.Join(parts, "_"), nil
+}
+
+func Migrate(client *mongo.Database, usersCollection, gamesCollection string, logger *logging.Logger) {
 	rand.Seed(time.Now().Unix())
-	log.Println("Started DB initialization...")
+	logger.Info("started DB initialization")
 
-	err := createIndexes(client)
+	err := CreateIndexes(client, usersCollection, gamesCollection)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("migration failed", "error", err)
 		return
 	}
-	insertedIds := insertUsers(client, log)
-	insertUserGames(client, log, insertedIds)
+	insertedIds := insertUsers(client, usersCollection, logger)
+	insertUserGames(client, usersCollection, gamesCollection, logger, insertedIds)
 }
@@ -0,0 +1,269 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	paramsKey contextKey = iota
+	routeKey
+	routeSlotKey
+)
+
+// Router dispatches requests by method and path pattern. Patterns are made of
+// literal segments and {name} parameters, e.g. /users/{uuid}/games. When more
+// than one pattern matches a path, literal segments win over parameters, so
+// /users/search takes precedence over /users/{uuid}.
+//
+// A path that matches with another method gets 405 with the Allow header,
+// HEAD is served by the GET handler and OPTIONS lists the allowed methods.
+type Router struct {
+	table  *table
+	prefix string
+}
+
+type table struct {
+	routes []*route
+	// notFound and methodNotAllowed replace the plain text default responses.
+	notFound         http.Handler
+	methodNotAllowed func(allow []string) http.Handler
+	middlewares      []func(http.Handler) http.Handler
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.Handler
+}
+
+func New() *Router {
+	return &Router{table: &table{}}
+}
+
+// Group returns a router registering its routes under prefix, e.g. /api/v1.
+func (rt *Router) Group(prefix string) *Router {
+	return &Router{table: rt.table, prefix: rt.prefix + strings.TrimSuffix(prefix, "/")}
+}
+
+// NotFound sets the handler for paths no pattern matches.
+func (rt *Router) NotFound(h http.Handler) {
+	rt.table.notFound = h
+}
+
+// MethodNotAllowed sets the handler for paths registered with other methods only.
+// The Allow header is already set when the handler runs.
+func (rt *Router) MethodNotAllowed(h func(allow []string) http.Handler) {
+	rt.table.methodNotAllowed = h
+}
+
+// Use adds middleware run after the route is matched, for every request the
+// router answers, including not found and method not allowed ones. Unlike
+// middleware wrapping the router, it sees the pattern through Route, so e.g.
+// rejected requests are still reported under their route.
+func (rt *Router) Use(middleware func(http.Handler) http.Handler) {
+	rt.table.middlewares = append(rt.table.middlewares, middleware)
+}
+
+func (rt *Router) Handle(method, pattern string, h http.Handler) {
+	pattern = rt.prefix + pattern
+	rt.table.routes = append(rt.table.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: split(pattern),
+		handler:  h,
+	})
+}
+
+func (rt *Router) HandleFunc(method, pattern string, h http.HandlerFunc) {
+	rt.Handle(method, pattern, h)
+}
+
+// Param returns the value of the named path parameter of the matched route.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey).(map[string]string)
+	return params[name]
+}
+
+// Route returns the pattern of the matched route, e.g. /api/users/{uuid},
+// or an empty string outside of the router.
+func Route(r *http.Request) string {
+	pattern, _ := r.Context().Value(routeKey).(string)
+	return pattern
+}
+
+// TrackRoute returns r with a slot the router fills with the pattern of the
+// matched route, and a func reading it. It lets middleware wrapping the router
+// see the pattern after the request was served. A slot already put into the
+// context by an outer middleware is shared, the router fills only the
+// innermost one.
+func TrackRoute(r *http.Request) (*http.Request, func() string) {
+	if slot, ok := r.Context().Value(routeSlotKey).(*string); ok {
+		return r, func() string {
+			return *slot
+		}
+	}
+	slot := new(string)
+	return r.WithContext(context.WithValue(r.Context(), routeSlotKey, slot)), func() string {
+		return *slot
+	}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := split(r.URL.Path)
+
+	var matched []*route
+	var best []string
+	for _, candidate := range rt.table.routes {
+		if !candidate.match(segments) {
+			continue
+		}
+		switch compare(candidate.segments, best) {
+		case 1:
+			matched, best = []*route{candidate}, candidate.segments
+		case 0:
+			matched = append(matched, candidate)
+		}
+	}
+	if len(matched) == 0 {
+		rt.serve(rt.notFound(), w, r)
+		return
+	}
+
+	route := find(matched, r.Method)
+	if route == nil && r.Method == http.MethodHead {
+		route = find(matched, http.MethodGet)
+	}
+	if route == nil {
+		allow := allowed(matched)
+		h := rt.methodNotAllowed(allow)
+		if r.Method == http.MethodOptions {
+			h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+		}
+		rt.serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			h.ServeHTTP(w, r)
+		}), w, r)
+		return
+	}
+
+	params := make(map[string]string)
+	for i, segment := range route.segments {
+		if name, ok := param(segment); ok {
+			params[name] = segments[i]
+		}
+	}
+	if slot, ok := r.Context().Value(routeSlotKey).(*string); ok {
+		*slot = route.pattern
+	}
+	ctx := context.WithValue(r.Context(), paramsKey, params)
+	ctx = context.WithValue(ctx, routeKey, route.pattern)
+	rt.serve(route.handler, w, r.WithContext(ctx))
+}
+
+// serve runs h behind the middlewares of the router, the first added outermost.
+func (rt *Router) serve(h http.Handler, w http.ResponseWriter, r *http.Request) {
+	for i := len(rt.table.middlewares) - 1; i >= 0; i-- {
+		h = rt.table.middlewares[i](h)
+	}
+	h.ServeHTTP(w, r)
+}
+
+func (rt *Router) notFound() http.Handler {
+	if rt.table.notFound != nil {
+		return rt.table.notFound
+	}
+	return http.NotFoundHandler()
+}
+
+func (rt *Router) methodNotAllowed(allow []string) http.Handler {
+	if rt.table.methodNotAllowed != nil {
+		return rt.table.methodNotAllowed(allow)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
+}
+
+func (r *route) match(segments []string) bool {
+	if len(segments) != len(r.segments) {
+		return false
+	}
+	for i, segment := range r.segments {
+		if _, ok := param(segment); ok {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func find(routes []*route, method string) *route {
+	for _, route := range routes {
+		if route.method == method {
+			return route
+		}
+	}
+	return nil
+}
+
+// allowed lists the methods of routes, with HEAD for GET and OPTIONS.
+func allowed(routes []*route) []string {
+	methods := map[string]bool{http.MethodOptions: true}
+	for _, route := range routes {
+		methods[route.method] = true
+		if route.method == http.MethodGet {
+			methods[http.MethodHead] = true
+		}
+	}
+	allow := make([]string, 0, len(methods))
+	for method := range methods {
+		allow = append(allow, method)
+	}
+	sort.Strings(allow)
+	return allow
+}
+
+// compare orders patterns of the same length by precedence: 1 when a has a
+// literal segment where b has the first parameter, -1 for the opposite and 0
+// for patterns of equal precedence. Any pattern takes precedence over nil.
+func compare(a, b []string) int {
+	if b == nil {
+		return 1
+	}
+	for i := range a {
+		_, aParam := param(a[i])
+		_, bParam := param(b[i])
+		if aParam != bParam {
+			if bParam {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+func param(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// split returns the segments of path, ignoring the trailing slash.
+func split(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
@@ -0,0 +1,157 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// named answers with name, the matched route and the uuid parameter.
+func named(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + " " + Route(r) + " " + Param(r, "uuid")))
+	}
+}
+
+func serve(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func newRouter() *Router {
+	rt := New()
+	group := rt.Group("/api/v1/")
+	group.HandleFunc(http.MethodGet, "/users/{uuid}", named("get"))
+	group.HandleFunc(http.MethodPut, "/users/{uuid}", named("put"))
+	group.HandleFunc(http.MethodGet, "/users/search", named("search"))
+	group.HandleFunc(http.MethodGet, "/users/{uuid}/games", named("games"))
+	rt.HandleFunc(http.MethodGet, "/healthz", named("health"))
+	return rt
+}
+
+func TestRouting(t *testing.T) {
+	rt := newRouter()
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   string
+	}{
+		{"param", http.MethodGet, "/api/v1/users/abc", "get /api/v1/users/{uuid} abc"},
+		{"method", http.MethodPut, "/api/v1/users/abc", "put /api/v1/users/{uuid} abc"},
+		{"literal over param", http.MethodGet, "/api/v1/users/search", "search /api/v1/users/search "},
+		{"nested", http.MethodGet, "/api/v1/users/abc/games", "games /api/v1/users/{uuid}/games abc"},
+		{"trailing slash", http.MethodGet, "/api/v1/users/abc/", "get /api/v1/users/{uuid} abc"},
+		{"head as get", http.MethodHead, "/api/v1/users/abc", "get /api/v1/users/{uuid} abc"},
+		{"without group", http.MethodGet, "/healthz", "health /healthz "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(rt, tt.method, tt.path)
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Errorf("%s %s = %d %q, want 200 %q", tt.method, tt.path, w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	rt := newRouter()
+	for _, path := range []string{"/users/abc", "/api/v1/users", "/api/v1/users//games", "/api/v2/users/abc"} {
+		if w := serve(rt, http.MethodGet, path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, w.Code)
+		}
+	}
+
+	rt.NotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	if w := serve(rt, http.MethodGet, "/nothing"); w.Code != http.StatusTeapot {
+		t.Errorf("GET /nothing = %d, want the custom not found handler", w.Code)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	rt := newRouter()
+
+	w := serve(rt, http.MethodDelete, "/api/v1/users/abc")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %d, want 405", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("Allow = %q, want GET, HEAD, OPTIONS, PUT", allow)
+	}
+
+	// the literal route wins, so only its methods are allowed
+	w = serve(rt, http.MethodPut, "/api/v1/users/search")
+	if allow := w.Header().Get("Allow"); w.Code != http.StatusMethodNotAllowed || allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("PUT /users/search = %d Allow %q, want 405 Allow GET, HEAD, OPTIONS", w.Code, allow)
+	}
+
+	var gotAllow []string
+	rt.MethodNotAllowed(func(allow []string) http.Handler {
+		gotAllow = allow
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	})
+	if w := serve(rt, http.MethodPost, "/healthz"); w.Code != http.StatusTeapot || strings.Join(gotAllow, ",") != "GET,HEAD,OPTIONS" {
+		t.Errorf("POST /healthz = %d with %v, want the custom handler with GET, HEAD, OPTIONS", w.Code, gotAllow)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	w := serve(newRouter(), http.MethodOptions, "/api/v1/users/abc")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS = %d, want 204", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("Allow = %q, want GET, HEAD, OPTIONS, PUT", allow)
+	}
+}
+
+func TestUse(t *testing.T) {
+	rt := newRouter()
+	var order []string
+	for _, name := range []string{"outer", "inner"} {
+		name := name
+		rt.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name+" "+Route(r))
+				if r.Header.Get("Deny") != "" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+
+	if w := serve(rt, http.MethodGet, "/api/v1/users/abc"); w.Body.String() != "get /api/v1/users/{uuid} abc" {
+		t.Errorf("GET = %q, want the route handler", w.Body.String())
+	}
+	if strings.Join(order, ",") != "outer /api/v1/users/{uuid},inner /api/v1/users/{uuid}" {
+		t.Errorf("middlewares ran %v, want outer then inner with the route", order)
+	}
+
+	// the middlewares also run for the responses without a route handler
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/nothing"},
+		{http.MethodDelete, "/api/v1/users/abc"},
+		{http.MethodOptions, "/api/v1/users/abc"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.Header.Set("Deny", "1")
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("Allow") != "" {
+			t.Errorf("denied %s %s = %d Allow %q, want 401 from the middleware", tt.method, tt.path, w.Code, w.Header().Get("Allow"))
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package shutdown
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/IvanKyrylov/user-game-api/pkg/logging"
 )
@@ -13,11 +17,40 @@ func Graceful(signals []os.Signal, closeItems ...io.Closer) {
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, signals...)
 	sig := <-sigc
-	logging.CommonLog.Printf("Caught signal %s. Shutting down...", sig)
+	logging.Default().Info("caught signal, shutting down", "signal", sig)
 
 	for _, closer := range closeItems {
 		if err := closer.Close(); err != nil {
-			logging.ErrorLog.Fatalf("failed to close %v: %v", closer, err)
+			logging.Default().Fatal("failed to close", "closer", fmt.Sprintf("%T", closer), "error", err)
 		}
 	}
 }
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// Drain returns a closer waiting d, so that load balancers polling the
+// readiness closed before it stop sending traffic while the server still
+// accepts connections.
+func Drain(d time.Duration) io.Closer {
+	return closerFunc(func() error {
+		if d > 0 {
+			logging.Default().Info("draining", "delay", d.String())
+			time.Sleep(d)
+		}
+		return nil
+	})
+}
+
+// Server returns a closer stopping server from accepting connections and
+// waiting up to timeout for the active requests to finish.
+func Server(server *http.Server, timeout time.Duration) io.Closer {
+	return closerFunc(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	})
+}